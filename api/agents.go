@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+type AgentRequest struct {
+	ID string `json:"id" binding:"required"`
+}
+
+// agentFilter reads the agent filter from the query parameters attestationType, selector
+// (repeatable, type:value), match, banned, canReattest and expiresBefore (RFC3339)
+func agentFilter(c *gin.Context) (grpc.AgentFilter, error) {
+	f := grpc.AgentFilter{
+		AttestationType: c.Query("attestationType"),
+		Selectors:       c.QueryArray("selector"),
+		SelectorMatch:   c.Query("match"),
+		ExpiresBefore:   c.Query("expiresBefore"),
+	}
+	ve := &grpc.ValidationError{}
+	for name, dst := range map[string]**bool{"banned": &f.Banned, "canReattest": &f.CanReattest} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: name, Message: "must be true or false"})
+			continue
+		}
+		*dst = &b
+	}
+	if f.ExpiresBefore != "" {
+		if _, err := time.Parse(time.RFC3339, f.ExpiresBefore); err != nil {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: "expiresBefore", Message: "must be an RFC3339 time"})
+		}
+	}
+	if len(ve.Fields) > 0 {
+		return f, ve
+	}
+	return f, nil
+}
+
+// bindAgentID decodes an AgentRequest and parses its SPIFFE ID, writing a 400 response on failure
+func bindAgentID(c *gin.Context) (string, bool) {
+	var req AgentRequest
+	if err := decodeStrict(c, &req); err != nil {
+		writeValidationError(c, err)
+		return "", false
+	}
+	if _, err := grpc.ParseSPIFFEID(req.ID); err != nil {
+		writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "id", Message: err.Error()}}})
+		return "", false
+	}
+	return req.ID, true
+}
+
+func ListAgents(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		f, err := agentFilter(c)
+		if err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		agents, err := sc.ListAgents(c.Request.Context(), f)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, agents)
+	}
+}
+
+func CountAgents(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		f, err := agentFilter(c)
+		if err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		count, err := sc.CountAgents(c.Request.Context(), f)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"count": count})
+	}
+}
+
+// GetAgent handles GET requests for one agent, selected with the id query parameter
+func GetAgent(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		id, err := grpc.ParseSPIFFEID(c.Query("id"))
+		if err != nil {
+			writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "id", Message: err.Error()}}})
+			return
+		}
+		agent, err := sc.GetAgent(c.Request.Context(), id)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, agent)
+	}
+}
+
+// BanAgent handles POST requests to ban an agent. A banned agent can't attest again until it is evicted.
+func BanAgent(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return agentAction(pe, al, policy.VerbBan, "agent.ban", sc.BanAgent, "Agent banned")
+}
+
+// EvictAgent handles POST requests to evict an agent. The agent has to attest again.
+func EvictAgent(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return agentAction(pe, al, policy.VerbEvict, "agent.evict", sc.EvictAgent, "Agent evicted")
+}
+
+func agentAction(pe *policy.Engine, al *audit.Log, verb string, op string, action func(ctx context.Context, id *types.SPIFFEID) error, msg string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindAgentID(c)
+		if !ok {
+			return
+		}
+		rec := newAuditRecord(c, op)
+		rec.SetInput(AgentRequest{ID: id})
+		rec.AgentIDs = []string{id}
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorize(c, pe, verb, nil) {
+			rec.Outcome = audit.OutcomeDenied
+			return
+		}
+		sid, _ := grpc.ParseSPIFFEID(id)
+		if err := action(c.Request.Context(), sid); err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"message": msg, "id": id})
+	}
+}
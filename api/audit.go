@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAuditRecord(c *gin.Context, op string) *audit.Record {
+	return &audit.Record{
+		Timestamp: time.Now().UTC(),
+		Caller:    callerID(c),
+		SourceIP:  c.ClientIP(),
+		RequestID: requestID(c),
+		Backend:   c.GetString(backendKey),
+		Operation: op,
+	}
+}
+
+// finishAuditRecord sets the outcome from errMsg and writes the record. A failure to audit is
+// logged but does not change the response, the mutation has already happened.
+func finishAuditRecord(al *audit.Log, rec *audit.Record, before map[string]string) {
+	rec.Files = audit.Changes(before)
+	if rec.Outcome == "" {
+		rec.Outcome = audit.OutcomeSuccess
+		if rec.Error != "" {
+			rec.Outcome = audit.OutcomeFailure
+		}
+	}
+	if err := al.Write(rec); err != nil {
+		al.Logger.Errorf("Failed to write audit record for request %s: %v", rec.RequestID, err)
+	}
+}
+
+// GetAudit handles GET requests to query the audit log.
+// Supported query parameters are since and until (RFC3339), actor, operation and limit.
+func GetAudit(al *audit.Log, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbAudit, nil) {
+			return
+		}
+		var f audit.Filter
+		var err error
+		if v := c.Query("since"); v != "" {
+			if f.Since, err = time.Parse(time.RFC3339, v); err != nil {
+				writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "since", Message: "must be an RFC3339 time"}}})
+				return
+			}
+		}
+		if v := c.Query("until"); v != "" {
+			if f.Until, err = time.Parse(time.RFC3339, v); err != nil {
+				writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "until", Message: "must be an RFC3339 time"}}})
+				return
+			}
+		}
+		if v := c.Query("limit"); v != "" {
+			if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit < 0 {
+				writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "limit", Message: "must be a non-negative integer"}}})
+				return
+			}
+		}
+		f.Actor = c.Query("actor")
+		f.Operation = c.Query("operation")
+
+		records, err := al.Query(f)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, records)
+	}
+}
+
+// authorizeAudited is authorize for audited requests, a denial is recorded on rec
+func authorizeAudited(c *gin.Context, pe *policy.Engine, verb string, rec *audit.Record) bool {
+	if authorize(c, pe, verb, nil) {
+		return true
+	}
+	rec.Outcome = audit.OutcomeDenied
+	return false
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAuditSourceIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		forwardedFor   string
+		want           string
+	}{
+		{name: "no proxies ignores X-Forwarded-For", remoteAddr: "10.0.0.5:4321", forwardedFor: "192.0.2.1", want: "10.0.0.5"},
+		{name: "trusted proxy sets the client", trustedProxies: []string{"10.0.0.0/24"}, remoteAddr: "10.0.0.5:4321", forwardedFor: "192.0.2.1", want: "192.0.2.1"},
+		{name: "untrusted proxy is ignored", trustedProxies: []string{"10.0.1.0/24"}, remoteAddr: "10.0.0.5:4321", forwardedFor: "192.0.2.1", want: "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			if err := router.SetTrustedProxies(tt.trustedProxies); err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			router.GET("/", func(c *gin.Context) { got = newAuditRecord(c, "test").SourceIP })
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("SourceIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
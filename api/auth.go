@@ -0,0 +1,65 @@
+package api
+
+import (
+	"spire-api/apierror"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// callerID returns the SPIFFE ID of the verified client certificate, or policy.AnonymousCaller.
+// Client certificates are only requested when the API is served with a client CA.
+func callerID(c *gin.Context) string {
+	if c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+		if id, err := x509svid.IDFromCert(c.Request.TLS.VerifiedChains[0][0]); err == nil {
+			return id.String()
+		}
+	}
+	return policy.AnonymousCaller
+}
+
+func entryFlags(e *grpc.Entry) []string {
+	var flags []string
+	if e.Admin {
+		flags = append(flags, policy.FlagAdmin)
+	}
+	if e.Downstream {
+		flags = append(flags, policy.FlagDownstream)
+	}
+	if e.KubeConfig != "" {
+		flags = append(flags, policy.FlagKubeConfig)
+	}
+	if e.EvictAgents {
+		flags = append(flags, policy.FlagEvict)
+	}
+	return flags
+}
+
+// authorize evaluates the policy for the request and writes a 403 response if it is denied.
+// e may be nil for requests that do not target an entry.
+func authorize(c *gin.Context, pe *policy.Engine, verb string, e *grpc.Entry) bool {
+	req := policy.Request{
+		Caller: callerID(c),
+		Verb:   verb,
+	}
+	if e != nil {
+		req.Cluster = e.Cluster
+		req.Namespace = e.Namespace
+		req.ServiceAccount = e.ServiceAccount
+		req.Flags = entryFlags(e)
+	}
+	return authorizeRequest(c, pe, req)
+}
+
+// authorizeRequest is authorize for a request built by the handler
+func authorizeRequest(c *gin.Context, pe *policy.Engine, req policy.Request) bool {
+	req.Backend = c.GetString(backendKey)
+	d := pe.Evaluate(c.Request.Context(), req)
+	if !d.Allowed {
+		writeError(c, apierror.New(apierror.PermissionDenied, "permission denied: %s", d.Reason))
+		return false
+	}
+	return true
+}
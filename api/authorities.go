@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"spire-api/apierror"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthorityRequest struct {
+	AuthorityID string `json:"authorityId" binding:"required"`
+}
+
+type authorityAuditInput struct {
+	Type        string `json:"type"`
+	AuthorityID string `json:"authorityId,omitempty"`
+	RotationID  string `json:"rotationId,omitempty"`
+}
+
+// GetAuthorities handles GET requests for the state of the local X.509 and JWT authorities
+func GetAuthorities(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		x509, err := sc.GetAuthorityStates(c.Request.Context(), grpc.AuthorityX509)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		jwt, err := sc.GetAuthorityStates(c.Request.Context(), grpc.AuthorityJWT)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{grpc.AuthorityX509: x509, grpc.AuthorityJWT: jwt})
+	}
+}
+
+// PrepareAuthority handles POST requests to prepare a new authority of the type in the path
+func PrepareAuthority(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		kind := c.Param("type")
+		rec := newAuditRecord(c, "authority.prepare")
+		rec.SetInput(authorityAuditInput{Type: kind})
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeAudited(c, pe, policy.VerbAuthority, rec) {
+			return
+		}
+		a, err := sc.PrepareAuthority(c.Request.Context(), kind)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, a)
+	}
+}
+
+func ActivateAuthority(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return authorityAction(pe, al, "authority.activate", sc.ActivateAuthority)
+}
+
+func TaintAuthority(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return authorityAction(pe, al, "authority.taint", sc.TaintAuthority)
+}
+
+func RevokeAuthority(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return authorityAction(pe, al, "authority.revoke", sc.RevokeAuthority)
+}
+
+func authorityAction(pe *policy.Engine, al *audit.Log, op string, action func(ctx context.Context, kind string, id string) (*grpc.Authority, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AuthorityRequest
+		if err := decodeStrict(c, &req); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		kind := c.Param("type")
+		rec := newAuditRecord(c, op)
+		rec.SetInput(authorityAuditInput{Type: kind, AuthorityID: req.AuthorityID})
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeAudited(c, pe, policy.VerbAuthority, rec) {
+			return
+		}
+		a, err := action(c.Request.Context(), kind, req.AuthorityID)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, a)
+	}
+}
+
+// GetRotation handles GET requests for the running or last guided rotation
+func GetRotation(rt *grpc.Rotator, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		r := rt.Current()
+		if r == nil {
+			writeError(c, apierror.New(apierror.NotFound, "no rotation was started"))
+			return
+		}
+		c.IndentedJSON(http.StatusOK, r)
+	}
+}
+
+// StartRotation handles POST requests with RotationOptions. The rotation runs in the background
+// and each step is audited as authority.rotate.<step> with the caller and request ID of the start.
+func StartRotation(rt *grpc.Rotator, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var opts grpc.RotationOptions
+		if err := decodeStrict(c, &opts); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		rec := newAuditRecord(c, "authority.rotate")
+		rec.SetInput(opts)
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeAudited(c, pe, policy.VerbAuthority, rec) {
+			return
+		}
+		onStep := func(r *grpc.Rotation, step grpc.RotationStep) {
+			stepRec := &audit.Record{
+				Timestamp: time.Now().UTC(),
+				Caller:    rec.Caller,
+				SourceIP:  rec.SourceIP,
+				RequestID: rec.RequestID,
+				Backend:   rec.Backend,
+				Operation: "authority.rotate." + step.Name,
+			}
+			stepRec.SetInput(authorityAuditInput{Type: r.Type, AuthorityID: r.PreparedAuthorityID, RotationID: r.ID})
+			if step.Status == grpc.RotationFailed {
+				stepRec.Error = step.Message
+			}
+			finishAuditRecord(al, stepRec, nil)
+		}
+		r, err := rt.Start(opts, onStep)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusAccepted, r)
+	}
+}
+
+// CancelRotation handles DELETE requests that stop the running rotation
+func CancelRotation(rt *grpc.Rotator, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rec := newAuditRecord(c, "authority.rotate.cancel")
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeAudited(c, pe, policy.VerbAuthority, rec) {
+			return
+		}
+		r, err := rt.Cancel()
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		rec.SetInput(authorityAuditInput{Type: r.Type, RotationID: r.ID})
+		c.IndentedJSON(http.StatusOK, r)
+	}
+}
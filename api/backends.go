@@ -0,0 +1,208 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"spire-api/apierror"
+	"spire-api/audit"
+	"spire-api/config"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultBackend is the name of the backend built from the server settings
+const DefaultBackend = config.DefaultBackend
+
+const backendKey = "backend"
+
+// Backend is a SPIRE server managed by this instance. Its routes are served under
+// /v1/backends/<name>, the default backend also serves the unprefixed /v1 routes.
+type Backend struct {
+	Name     string
+	Client   *grpc.SPIREClient
+	SpireDir string
+	Rotator  *grpc.Rotator
+	Default  bool
+}
+
+type BackendInfo struct {
+	Name        string   `json:"name"`
+	Server      string   `json:"server"`
+	Replicas    []string `json:"replicas,omitempty"`
+	TrustDomain string   `json:"trustDomain"`
+	Default     bool     `json:"default"`
+}
+
+// openBackends connects to every backend, retrying until ctx is done, and prepares its kubeconfigs.
+// With more than one backend each gets its own kubeconfig runtime dir so equal cluster names do not collide.
+func openBackends(ctx context.Context, cfgs []grpc.BackendConfig, d grpc.ConnectorDefaults, kce grpc.KubeconfigEncryption) ([]*Backend, error) {
+	var backends []*Backend
+	for _, cfg := range cfgs {
+		b, err := openBackend(ctx, cfg, d, kce, len(cfgs) > 1)
+		if err != nil {
+			closeBackends(backends)
+			return nil, fmt.Errorf("backend %s: %w", cfg.Name, err)
+		}
+		backends = append(backends, b)
+	}
+	return backends, nil
+}
+
+func openBackend(ctx context.Context, cfg grpc.BackendConfig, d grpc.ConnectorDefaults, kce grpc.KubeconfigEncryption, ownRuntimeDir bool) (*Backend, error) {
+	conn, err := cfg.Connector(d)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := grpc.ConnectWithRetry(ctx, cfg.Name, conn, d.Logger)
+	if err != nil {
+		return nil, err
+	}
+	sc.Name = cfg.Name
+	sc.Reload = cfg.Reload
+	sc.Replicas = cfg.ReplicaConfigs()
+	if ownRuntimeDir && kce.RuntimeDir != "" {
+		kce.RuntimeDir = filepath.Join(kce.RuntimeDir, cfg.Name)
+	}
+	if sc.KubeconfigCrypto, err = grpc.NewKubeconfigCrypto(kce); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	if err := sc.SyncKubeconfigs(ctx, cfg.SpireDir); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return &Backend{
+		Name:     cfg.Name,
+		Client:   sc,
+		SpireDir: cfg.SpireDir,
+		Rotator:  grpc.NewRotator(sc),
+		Default:  cfg.Default,
+	}, nil
+}
+
+func closeBackends(backends []*Backend) {
+	for _, b := range backends {
+		if err := b.Client.Close(); err != nil {
+			b.Client.Logger.Errorf("Failed to close connection to backend %s: %v", b.Name, err)
+		}
+	}
+}
+
+func defaultBackend(backends []*Backend) *Backend {
+	for _, b := range backends {
+		if b.Default {
+			return b
+		}
+	}
+	return backends[0]
+}
+
+// withBackend records the backend on the context for the audit log and the policy
+func withBackend(b *Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(backendKey, b.Name)
+	}
+}
+
+// registerBackendRoutes registers the routes of b relative to a /v1 group, except the entry
+// mutations which registerEntryRoutes adds so the default backend can dispatch them.
+func registerBackendRoutes(r gin.IRoutes, b *Backend, pe *policy.Engine, al *audit.Log) {
+	sc, rt := b.Client, b.Rotator
+	r.GET("/server/info", GetServerInfo(sc, pe))
+	r.GET("/entries", GetEntries(sc, pe))
+	r.GET("/agents", ListAgents(sc, pe))
+	r.GET("/agents/count", CountAgents(sc, pe))
+	r.GET("/agents/show", GetAgent(sc, pe))
+	r.POST("/agents/ban", BanAgent(sc, pe, al))
+	r.POST("/agents/evict", EvictAgent(sc, pe, al))
+	r.POST("/jointokens", CreateJoinToken(sc, pe, al))
+	r.GET("/bundle", GetBundle(sc))
+	r.GET("/federation/bundles", ListFederatedBundles(sc, pe))
+	r.POST("/federation/bundles", CreateFederatedBundle(sc, pe, al))
+	r.GET("/federation/bundles/:trustDomain", GetFederatedBundle(sc, pe))
+	r.PUT("/federation/bundles/:trustDomain", SetFederatedBundle(sc, pe, al))
+	r.PATCH("/federation/bundles/:trustDomain", UpdateFederatedBundle(sc, pe, al))
+	r.DELETE("/federation/bundles/:trustDomain", DeleteFederatedBundle(sc, pe, al))
+	r.GET("/federation/relationships", ListFederationRelationships(sc, pe))
+	r.POST("/federation/relationships", CreateFederationRelationship(sc, pe, al))
+	r.GET("/federation/relationships/:trustDomain", GetFederationRelationship(sc, pe))
+	r.PUT("/federation/relationships/:trustDomain", UpdateFederationRelationship(sc, pe, al))
+	r.DELETE("/federation/relationships/:trustDomain", DeleteFederationRelationship(sc, pe, al))
+	r.POST("/federation/relationships/:trustDomain/refresh", RefreshFederationRelationship(sc, pe, al))
+	r.POST("/svids/x509", MintX509SVID(sc, pe, al))
+	r.POST("/svids/jwt", MintJWTSVID(sc, pe, al))
+	r.GET("/authorities", GetAuthorities(sc, pe))
+	r.GET("/authorities/rotation", GetRotation(rt, pe))
+	r.POST("/authorities/rotation", StartRotation(rt, pe, al))
+	r.DELETE("/authorities/rotation", CancelRotation(rt, pe, al))
+	r.POST("/authorities/:type/prepare", PrepareAuthority(sc, pe, al))
+	r.POST("/authorities/:type/activate", ActivateAuthority(sc, pe, al))
+	r.POST("/authorities/:type/taint", TaintAuthority(sc, pe, al))
+	r.POST("/authorities/:type/revoke", RevokeAuthority(sc, pe, al))
+}
+
+func registerEntryRoutes(r gin.IRoutes, b *Backend, pe *policy.Engine, al *audit.Log) {
+	r.POST("/entries/add", CreateEntry(b.Client, b.SpireDir, pe, al))
+	r.POST("/entries/delete", DeleteEntry(b.Client, b.SpireDir, pe, al))
+}
+
+// byTrustDomain dispatches an entry request to the backend of the trustDomain in its body.
+// Unknown trust domains go to the default backend, whose validation rejects them.
+func byTrustDomain(backends []*Backend, handler func(b *Backend) gin.HandlerFunc) gin.HandlerFunc {
+	def := defaultBackend(backends)
+	byTD := map[string]*Backend{}
+	handlers := map[string]gin.HandlerFunc{}
+	for _, b := range backends {
+		byTD[b.Client.TrustDomain] = b
+		handlers[b.Name] = handler(b)
+	}
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			writeError(c, apierror.New(apierror.Validation, "failed to read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			TrustDomain string `json:"trustDomain"`
+		}
+		// a malformed body is reported by the handler's strict decoding
+		_ = json.Unmarshal(body, &req)
+		b, ok := byTD[req.TrustDomain]
+		if !ok {
+			b = def
+		}
+		c.Set(backendKey, b.Name)
+		handlers[b.Name](c)
+	}
+}
+
+// ListBackends handles GET requests for the configured backends
+func ListBackends(backends []*Backend, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		out := []BackendInfo{}
+		for _, b := range backends {
+			info := BackendInfo{
+				Name:        b.Name,
+				Server:      b.Client.ServerAddress,
+				TrustDomain: b.Client.TrustDomain,
+				Default:     b.Default,
+			}
+			for _, r := range b.Client.Replicas {
+				info.Replicas = append(info.Replicas, r.Address)
+			}
+			out = append(out, info)
+		}
+		c.IndentedJSON(http.StatusOK, out)
+	}
+}
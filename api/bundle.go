@@ -0,0 +1,71 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	grpc "spire-api/spire-grpc"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var bundleContentTypes = map[string]string{
+	grpc.BundleFormatPEM:    "application/x-pem-file",
+	grpc.BundleFormatJWKS:   "application/json",
+	grpc.BundleFormatSPIFFE: "application/json",
+}
+
+// GetBundle handles GET requests for the local trust bundle, in the format given by the
+// format query parameter (pem, jwks or spiffe, default pem). The response carries an ETag
+// and a matching If-None-Match returns 304, so agents and verifiers can poll cheaply.
+// The trust bundle is public, it is served without authorization so agents can bootstrap.
+func GetBundle(sc *grpc.SPIREClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveBundle(c, sc, c.DefaultQuery("format", grpc.BundleFormatPEM))
+	}
+}
+
+// GetBundlePEM serves the bundle in PEM at the path agents used with the nginx bundle endpoint
+func GetBundlePEM(sc *grpc.SPIREClient) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		serveBundle(c, sc, grpc.BundleFormatPEM)
+	}
+}
+
+func serveBundle(c *gin.Context, sc *grpc.SPIREClient, format string) {
+	contentType, ok := bundleContentTypes[format]
+	if !ok {
+		writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "format", Message: "must be one of pem, jwks, spiffe"}}})
+		return
+	}
+	b, err := sc.GetBundle(c.Request.Context())
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	data, err := grpc.MarshalBundle(b, format)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+type FederatedBundleRequest struct {
+	TrustDomain string `json:"trustDomain" binding:"required"`
+	grpc.FederatedBundleInput
+}
+
+type FederatedBundle struct {
+	TrustDomain string `json:"trustDomain"`
+	// Bundle is the SPIFFE bundle JSON
+	Bundle json.RawMessage `json:"bundle"`
+}
+
+// federatedBundleAuditInput records the bundle by hash, the bundle itself is public but large
+type federatedBundleAuditInput struct {
+	TrustDomain  string `json:"trustDomain"`
+	Format       string `json:"format,omitempty"`
+	BundleSHA256 string `json:"bundleSha256,omitempty"`
+	Mode         string `json:"mode,omitempty"`
+}
+
+func toFederatedBundle(b *types.Bundle) (FederatedBundle, error) {
+	data, err := grpc.MarshalBundle(b, grpc.BundleFormatSPIFFE)
+	if err != nil {
+		return FederatedBundle{}, err
+	}
+	return FederatedBundle{TrustDomain: b.TrustDomain, Bundle: data}, nil
+}
+
+func ListFederatedBundles(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		bundles, err := sc.ListFederatedBundles(c.Request.Context())
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		out := []FederatedBundle{}
+		for _, b := range bundles {
+			fb, err := toFederatedBundle(b)
+			if err != nil {
+				writeError(c, err)
+				return
+			}
+			out = append(out, fb)
+		}
+		c.IndentedJSON(http.StatusOK, out)
+	}
+}
+
+// GetFederatedBundle handles GET requests for the bundle of one trust domain, in the format
+// given by the format query parameter (pem, jwks or spiffe, default spiffe).
+func GetFederatedBundle(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		format := c.DefaultQuery("format", grpc.BundleFormatSPIFFE)
+		contentType, ok := bundleContentTypes[format]
+		if !ok {
+			writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "format", Message: "must be one of pem, jwks, spiffe"}}})
+			return
+		}
+		b, err := sc.GetFederatedBundle(c.Request.Context(), c.Param("trustDomain"))
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		data, err := grpc.MarshalBundle(b, format)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.Data(http.StatusOK, contentType, data)
+	}
+}
+
+// CreateFederatedBundle handles POST requests with a FederatedBundleRequest.
+// It fails if the trust domain already has a bundle.
+func CreateFederatedBundle(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req FederatedBundleRequest
+		if err := decodeStrict(c, &req); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		writeFederatedBundle(c, sc, pe, al, "federation.bundle.create", req.TrustDomain, req.FederatedBundleInput, sc.CreateFederatedBundle)
+	}
+}
+
+// SetFederatedBundle handles PUT requests for a trust domain, creating or replacing its bundle
+func SetFederatedBundle(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in grpc.FederatedBundleInput
+		if err := decodeStrict(c, &in); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		writeFederatedBundle(c, sc, pe, al, "federation.bundle.set", c.Param("trustDomain"), in, sc.SetFederatedBundle)
+	}
+}
+
+// UpdateFederatedBundle handles PATCH requests for a trust domain. It fails if there is no bundle.
+func UpdateFederatedBundle(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in grpc.FederatedBundleInput
+		if err := decodeStrict(c, &in); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		writeFederatedBundle(c, sc, pe, al, "federation.bundle.update", c.Param("trustDomain"), in, sc.UpdateFederatedBundle)
+	}
+}
+
+func writeFederatedBundle(c *gin.Context, sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log, op string, td string,
+	in grpc.FederatedBundleInput, write func(ctx context.Context, b *types.Bundle) (*types.Bundle, error)) {
+	if td == sc.TrustDomain {
+		writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "trustDomain", Message: "must be a foreign trust domain"}}})
+		return
+	}
+	b, err := grpc.ParseFederatedBundle(td, in)
+	if err != nil {
+		writeValidationError(c, err)
+		return
+	}
+	sum := sha256.Sum256([]byte(in.Bundle))
+	rec := newAuditRecord(c, op)
+	rec.SetInput(federatedBundleAuditInput{TrustDomain: td, Format: in.Format, BundleSHA256: hex.EncodeToString(sum[:])})
+	defer finishAuditRecord(al, rec, nil)
+
+	if !authorizeAudited(c, pe, policy.VerbFederate, rec) {
+		return
+	}
+	b, err = write(c.Request.Context(), b)
+	if err != nil {
+		rec.Error = err.Error()
+		writeError(c, err)
+		return
+	}
+	fb, err := toFederatedBundle(b)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, fb)
+}
+
+// DeleteFederatedBundle handles DELETE requests for a trust domain. The mode query parameter
+// is restrict (default), delete or dissociate and controls entries that federate with it.
+func DeleteFederatedBundle(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		td := c.Param("trustDomain")
+		mode := c.Query("mode")
+		rec := newAuditRecord(c, "federation.bundle.delete")
+		rec.SetInput(federatedBundleAuditInput{TrustDomain: td, Mode: mode})
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeAudited(c, pe, policy.VerbFederate, rec) {
+			return
+		}
+		if err := sc.DeleteFederatedBundle(c.Request.Context(), td, mode); err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Federated bundle deleted", "trustDomain": td})
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Healthz handles GET requests for the health of spire-api and the SPIRE servers it manages.
+// It returns 503 if a SPIRE server health check fails or a Workload API source has no
+// valid SVID. Like the bundle it is served without authorization so probes can use it.
+func Healthz(backends []*Backend) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, code := "ok", http.StatusOK
+		results := map[string]map[string]grpc.HealthCheck{}
+		for _, b := range backends {
+			checks := map[string]grpc.HealthCheck{
+				"spireServer": b.Client.CheckServer(c.Request.Context()),
+				"x509Source":  b.Client.CheckX509Source(),
+			}
+			for _, check := range checks {
+				if !check.Healthy {
+					status, code = "unhealthy", http.StatusServiceUnavailable
+				}
+			}
+			results[b.Name] = checks
+		}
+		c.IndentedJSON(code, gin.H{"status": status, "backends": results})
+	}
+}
+
+// GetServerInfo handles GET requests for the SPIRE server info from the debug API
+func GetServerInfo(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		info, err := sc.GetServerInfo(c.Request.Context())
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, info)
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"spire-api/apierror"
+	"spire-api/audit"
+	"spire-api/logging"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+type JoinTokenRequest struct {
+	// TTL in seconds, defaults to grpc.DefaultJoinTokenTTL
+	TTL int32 `json:"ttl,omitempty" binding:"gte=0"`
+	// AgentID is the SPIFFE ID given to the agent, optional
+	AgentID string `json:"agentId,omitempty"`
+	// TrustBundleURL is written to the generated agent.conf
+	TrustBundleURL string               `json:"trustBundleUrl,omitempty"`
+	Entries        []grpc.WorkloadEntry `json:"entries,omitempty" binding:"omitempty,dive"`
+}
+
+// validate checks that every SPIFFE ID is in the server's trust domain and every selector is
+// type:value, before the token is created
+func (r *JoinTokenRequest) validate(sc *grpc.SPIREClient) (*types.SPIFFEID, error) {
+	ve := &grpc.ValidationError{}
+	var agentID *types.SPIFFEID
+	if r.AgentID != "" {
+		id, err := grpc.ParseSPIFFEID(r.AgentID)
+		if err != nil {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: "agentId", Message: err.Error()})
+		} else if id.TrustDomain != sc.TrustDomain {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: "agentId", Message: fmt.Sprintf("must be in trust domain %q", sc.TrustDomain)})
+		} else {
+			agentID = id
+		}
+	}
+	for i, we := range r.Entries {
+		field := fmt.Sprintf("entries[%d].spiffeId", i)
+		id, err := grpc.ParseSPIFFEID(we.SpiffeID)
+		if err != nil {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: field, Message: err.Error()})
+		} else if id.TrustDomain != sc.TrustDomain {
+			ve.Fields = append(ve.Fields, grpc.FieldError{Field: field, Message: fmt.Sprintf("must be in trust domain %q", sc.TrustDomain)})
+		}
+		for _, s := range we.Selectors {
+			if _, err := grpc.ParseSelector(s); err != nil {
+				ve.Fields = append(ve.Fields, grpc.FieldError{Field: fmt.Sprintf("entries[%d].selectors", i), Message: err.Error()})
+			}
+		}
+	}
+	if len(ve.Fields) > 0 {
+		return nil, ve
+	}
+	return agentID, nil
+}
+
+// workloadEntryFlags is entryFlags for an entry of a join token request
+func workloadEntryFlags(we grpc.WorkloadEntry) []string {
+	var flags []string
+	if we.Admin {
+		flags = append(flags, policy.FlagAdmin)
+	}
+	if we.Downstream {
+		flags = append(flags, policy.FlagDownstream)
+	}
+	return flags
+}
+
+// CreateJoinToken handles POST requests to create a join token for a non-Kubernetes agent.
+// Entries in the request are created parented to the new agent in the same call, and the
+// response carries an agent.conf ready to use with the token.
+func CreateJoinToken(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req JoinTokenRequest
+		if err := decodeStrict(c, &req); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		agentID, err := req.validate(sc)
+		if err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		rec := newAuditRecord(c, "jointoken.create")
+		rec.SetInput(req)
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeRequest(c, pe, policy.Request{Caller: callerID(c), Verb: policy.VerbToken}) {
+			rec.Outcome = audit.OutcomeDenied
+			return
+		}
+		// each entry is authorized like any other entry create, before the token exists
+		for _, we := range req.Entries {
+			if !authorizeRequest(c, pe, policy.Request{Caller: callerID(c), Verb: policy.VerbCreate, SPIFFEID: we.SpiffeID, Flags: workloadEntryFlags(we)}) {
+				rec.Outcome = audit.OutcomeDenied
+				return
+			}
+		}
+
+		token, agentID, err := sc.CreateJoinToken(c.Request.Context(), req.TTL, agentID)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		// Never record the token itself, it is a bootstrap credential. The default agent ID holds it
+		// and is masked by the audit log.
+		rec.AgentIDs = []string{grpc.SPIFFEIDString(agentID)}
+
+		entryIDs, err := sc.CreateWorkloadEntries(c.Request.Context(), agentID, req.Entries)
+		if err != nil {
+			rec.Error = err.Error()
+			// the entries are created all or none, those created before the failure are deleted
+			deleted, derr := sc.DeleteEntries(c.Request.Context(), entryIDs)
+			if derr != nil {
+				logging.From(c.Request.Context(), sc.Logger).Errorf("Failed to delete the entries of the failed join token request: %v", derr)
+			}
+			rec.EntryIDs = remaining(entryIDs, deleted)
+			writeError(c, tokenError(err, token, agentID, rec.EntryIDs))
+			return
+		}
+		rec.EntryIDs = entryIDs
+		agentConf, err := sc.AgentConf(token.Value, req.TrustBundleURL)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, grpc.JoinTokenResult{
+			Token:     token.Value,
+			ExpiresAt: token.ExpiresAt,
+			AgentID:   grpc.SPIFFEIDString(agentID),
+			EntryIDs:  entryIDs,
+			AgentConf: agentConf,
+		})
+	}
+}
+
+// tokenError returns the error of a join token request that failed after the token was created.
+// SPIRE has no RPC to delete a join token, so the details hold the token to use or to let
+// expire, and the entries that could not be deleted.
+func tokenError(err error, token *types.JoinToken, agentID *types.SPIFFEID, entryIDs []string) *apierror.Error {
+	ae := apierror.From(err)
+	details := gin.H{
+		"token":     token.Value,
+		"expiresAt": token.ExpiresAt,
+		"agentId":   grpc.SPIFFEIDString(agentID),
+		"entryIds":  entryIDs,
+	}
+	if d, ok := ae.Details.(map[string]string); ok {
+		for k, v := range d {
+			details[k] = v
+		}
+	}
+	return ae.WithDetails(details)
+}
+
+// remaining returns the IDs of ids that are not in deleted
+func remaining(ids, deleted []string) []string {
+	var left []string
+	for _, id := range ids {
+		if !slices.Contains(deleted, id) {
+			left = append(left, id)
+		}
+	}
+	return left
+}
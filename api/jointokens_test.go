@@ -0,0 +1,212 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func quietLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func TestCreateJoinTokenBackendPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// the denied requests never reach the SPIRE server, the client has no connection
+	sc := &grpc.SPIREClient{Logger: quietLogger(), TrustDomain: "example.org"}
+	pe, err := policy.New(&policy.Policy{
+		DefaultEffect: policy.Allow,
+		Rules: []policy.Rule{
+			{Name: "no-prod-tokens", Effect: policy.Deny, Verbs: []string{policy.VerbToken}, Backends: []string{"prod"}},
+			{Name: "no-ops-entries", Effect: policy.Deny, Verbs: []string{policy.VerbCreate}, SPIFFEIDs: []string{"spiffe://example.org/ops/*"}},
+		},
+	}, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"), quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+
+	tests := []struct {
+		name    string
+		backend string
+		body    string
+		want    int
+	}{
+		{name: "backend rule denies the token", backend: "prod", body: `{}`, want: http.StatusForbidden},
+		{name: "admin entries need the admin flag", backend: "staging", body: `{"entries":[{"spiffeId":"spiffe://example.org/ops","selectors":["unix:uid:0"],"admin":true}]}`, want: http.StatusForbidden},
+		{name: "downstream entries need the downstream flag", backend: "staging", body: `{"entries":[{"spiffeId":"spiffe://example.org/nested","selectors":["unix:uid:0"],"downstream":true}]}`, want: http.StatusForbidden},
+		{
+			name:    "entry rules apply to every entry",
+			backend: "staging",
+			body:    `{"entries":[{"spiffeId":"spiffe://example.org/web","selectors":["unix:uid:1000"]},{"spiffeId":"spiffe://example.org/ops/root","selectors":["unix:uid:0"]}]}`,
+			want:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.POST("/jointokens", func(c *gin.Context) { c.Set(backendKey, tt.backend) }, CreateJoinToken(sc, pe, al))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/jointokens", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			records, err := al.Query(audit.Filter{Operation: "jointoken.create"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if last := records[len(records)-1]; last.Outcome != audit.OutcomeDenied || last.Backend != tt.backend {
+				t.Errorf("audit outcome %q on backend %q, want %q on %q", last.Outcome, last.Backend, audit.OutcomeDenied, tt.backend)
+			}
+		})
+	}
+}
+
+// tokenAgents creates join tokens, unset RPCs panic
+type tokenAgents struct {
+	agentpb.AgentClient
+	tokens int
+}
+
+func (f *tokenAgents) CreateJoinToken(context.Context, *agentpb.CreateJoinTokenRequest, ...ggrpc.CallOption) (*types.JoinToken, error) {
+	f.tokens++
+	return &types.JoinToken{Value: "token-value", ExpiresAt: 1700000000}, nil
+}
+
+// workloadEntries creates the entries whose SPIFFE ID is not in fail and records deletions
+type workloadEntries struct {
+	entrypb.EntryClient
+	fail    map[string]codes.Code
+	deleted []string
+}
+
+func (f *workloadEntries) BatchCreateEntry(_ context.Context, req *entrypb.BatchCreateEntryRequest, _ ...ggrpc.CallOption) (*entrypb.BatchCreateEntryResponse, error) {
+	resp := &entrypb.BatchCreateEntryResponse{}
+	for i, e := range req.Entries {
+		if code, ok := f.fail[e.SpiffeId.Path]; ok {
+			resp.Results = append(resp.Results, &entrypb.BatchCreateEntryResponse_Result{Status: &types.Status{Code: int32(code), Message: "rejected"}})
+			continue
+		}
+		resp.Results = append(resp.Results, &entrypb.BatchCreateEntryResponse_Result{
+			Status: &types.Status{},
+			Entry:  &types.Entry{Id: fmt.Sprintf("entry-%d", i)},
+		})
+	}
+	return resp, nil
+}
+
+func (f *workloadEntries) BatchDeleteEntry(_ context.Context, req *entrypb.BatchDeleteEntryRequest, _ ...ggrpc.CallOption) (*entrypb.BatchDeleteEntryResponse, error) {
+	resp := &entrypb.BatchDeleteEntryResponse{}
+	for _, id := range req.Ids {
+		f.deleted = append(f.deleted, id)
+		resp.Results = append(resp.Results, &entrypb.BatchDeleteEntryResponse_Result{Id: id, Status: &types.Status{}})
+	}
+	return resp, nil
+}
+
+func TestCreateJoinTokenEntries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	pe, err := policy.New(&policy.Policy{DefaultEffect: policy.Allow}, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const entries = `{"entries":[
+		{"spiffeId":"spiffe://example.org/web","selectors":["unix:uid:1000"]},
+		{"spiffeId":"spiffe://example.org/db","selectors":["unix:uid:1001"]},
+		{"spiffeId":"spiffe://example.org/cache","selectors":["unix:uid:1002"]}]}`
+	tests := []struct {
+		name    string
+		body    string
+		fail    map[string]codes.Code
+		want    int
+		tokens  int
+		deleted []string
+	}{
+		{name: "created", body: entries, want: http.StatusOK, tokens: 1},
+		{
+			name:   "invalid selector before the token",
+			body:   `{"entries":[{"spiffeId":"spiffe://example.org/web","selectors":["unix"]}]}`,
+			want:   http.StatusBadRequest,
+			tokens: 0,
+		},
+		{
+			name:    "failed entry deletes the created ones",
+			body:    entries,
+			fail:    map[string]codes.Code{"/db": codes.AlreadyExists},
+			want:    http.StatusConflict,
+			tokens:  1,
+			deleted: []string{"entry-0", "entry-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := &tokenAgents{}
+			client := &workloadEntries{fail: tt.fail}
+			sc := &grpc.SPIREClient{Logger: quietLogger(), TrustDomain: "example.org", Agents: agents, Client: client}
+			al, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"), quietLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer al.Close()
+
+			router := gin.New()
+			router.POST("/jointokens", CreateJoinToken(sc, pe, al))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/jointokens", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if agents.tokens != tt.tokens {
+				t.Errorf("created %d tokens, want %d", agents.tokens, tt.tokens)
+			}
+			if strings.Join(client.deleted, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted entries %v, want %v", client.deleted, tt.deleted)
+			}
+			if tt.deleted == nil {
+				return
+			}
+			var body struct {
+				Details struct {
+					Token    string   `json:"token"`
+					EntryIDs []string `json:"entryIds"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Details.Token != "token-value" || len(body.Details.EntryIDs) != 0 {
+				t.Errorf("details = %+v, want the token without entries", body.Details)
+			}
+			records, err := al.Query(audit.Filter{Operation: "jointoken.create"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0].Outcome != audit.OutcomeFailure || len(records[0].EntryIDs) != 0 {
+				t.Errorf("audit records = %+v, want one failure without entries", records)
+			}
+		})
+	}
+}
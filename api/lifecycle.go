@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"spire-api/apierror"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Readiness states of the API
+const (
+	stateStarting = "starting"
+	stateReady    = "ready"
+	stateDraining = "draining"
+)
+
+// readiness is the lifecycle state reported by /readyz
+type readiness struct {
+	state atomic.Value
+}
+
+func newReadiness() *readiness {
+	r := &readiness{}
+	r.state.Store(stateStarting)
+	return r
+}
+
+func (r *readiness) set(state string) {
+	r.state.Store(state)
+}
+
+func (r *readiness) get() string {
+	return r.state.Load().(string)
+}
+
+// ErrDrainTimeout is returned by Start when mutating requests were still running at the
+// shutdown deadline, the SPIRE server and its config may be partially changed.
+var ErrDrainTimeout = errors.New("mutations still running at the shutdown deadline")
+
+// mutations tracks the in-flight mutating requests so shutdown can wait for them and name
+// those that did not finish
+type mutations struct {
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	next   uint64
+	active map[uint64]string
+}
+
+func newMutations() *mutations {
+	return &mutations{active: map[uint64]string{}}
+}
+
+// track is a middleware counting every request that is not a GET, HEAD or OPTIONS
+func (m *mutations) track() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		m.mu.Lock()
+		m.next++
+		id := m.next
+		m.active[id] = fmt.Sprintf("%s %s (request %s)", c.Request.Method, c.Request.URL.Path, requestID(c))
+		m.wg.Add(1)
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.active, id)
+			m.mu.Unlock()
+			m.wg.Done()
+		}()
+		c.Next()
+	}
+}
+
+// wait waits until no mutation is running, or returns ErrDrainTimeout naming the running ones when ctx is done
+func (m *mutations) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var running []string
+	for _, desc := range m.active {
+		running = append(running, desc)
+	}
+	sort.Strings(running)
+	return fmt.Errorf("%w: %s", ErrDrainTimeout, strings.Join(running, ", "))
+}
+
+// handlerSwitch serves the starting router until the backends are connected, then the API router
+type handlerSwitch struct {
+	h atomic.Value
+}
+
+func (hs *handlerSwitch) set(h http.Handler) {
+	hs.h.Store(&h)
+}
+
+func (hs *handlerSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*hs.h.Load().(*http.Handler)).ServeHTTP(w, r)
+}
+
+// Readyz handles GET requests for the readiness of spire-api. It returns 503 while the SPIRE
+// servers are connected at startup and while draining on shutdown, so load balancers only send
+// requests to an instance that can serve them.
+func Readyz(r *readiness) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := r.get()
+		code := http.StatusOK
+		if state != stateReady {
+			code = http.StatusServiceUnavailable
+		}
+		c.IndentedJSON(code, gin.H{"status": state})
+	}
+}
+
+// startingRouter answers every request with 503 until the backends are connected
+func startingRouter(r *readiness, trustedProxies []string, logger *logrus.Logger) (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.Use(requestIDs(), accessLog(logger), recovery(logger))
+	router.GET("/readyz", Readyz(r))
+	router.GET("/metrics", Metrics())
+	router.NoRoute(func(c *gin.Context) {
+		writeError(c, apierror.New(apierror.UpstreamUnavailable, "spire-api is %s", r.get()))
+	})
+	return router, nil
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"runtime/debug"
+	"spire-api/apierror"
+	"spire-api/logging"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits propagated request IDs, they end up in every log line and audit record
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDs propagates the X-Request-ID of the client, or generates one, echoes it back and
+// puts it on the request context so every line logged for the request carries it.
+func requestIDs() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Writer.Header().Set(requestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// requestID returns the request ID set by requestIDs
+func requestID(c *gin.Context) string {
+	return logging.RequestID(c.Request.Context())
+}
+
+// accessLog logs every request once it is served. The path is logged without the query string,
+// which can carry tokens.
+func accessLog(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := logging.From(c.Request.Context(), logger).WithFields(logrus.Fields{
+			"status":     c.Writer.Status(),
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip":  c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+		entry.Info("request served")
+	}
+}
+
+// recovery answers a panicking request with an internal error body. The panic is logged with
+// the request ID through logger, so it is redacted like every other line, instead of gin's dump.
+func recovery(logger *logrus.Logger) gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		logging.From(c.Request.Context(), logger).WithField("stack", string(debug.Stack())).
+			Errorf("Panic serving %s %s: %v", c.Request.Method, c.Request.URL.Path, recovered)
+		writeError(c, apierror.New(apierror.Internal, "internal error"))
+		c.Abort()
+	})
+}
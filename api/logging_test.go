@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spire-api/apierror"
+	"spire-api/redact"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	logger := redact.NewLogger()
+	logger.SetOutput(&out)
+
+	router := gin.New()
+	router.Use(requestIDs(), recovery(logger))
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	var body apierror.Body
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q: %v", w.Body, err)
+	}
+	if body.Code != apierror.Internal || body.RequestID != "req-1" {
+		t.Errorf("body = %+v, want code %s with request ID req-1", body, apierror.Internal)
+	}
+	if !strings.Contains(out.String(), "boom") || !strings.Contains(out.String(), "req-1") {
+		t.Errorf("panic not logged with the request ID: %s", out.String())
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "propagated", header: "4bf92f35-77b3.4736:a", keep: true},
+		{name: "absent"},
+		{name: "log injection", header: "id\nlevel=error"},
+		{name: "spaces", header: "a b"},
+		{name: "too long", header: strings.Repeat("a", 129)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			router := gin.New()
+			router.Use(requestIDs())
+			router.GET("/", func(c *gin.Context) { seen = requestID(c) })
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(requestIDHeader, tt.header)
+			}
+			router.ServeHTTP(w, req)
+
+			echoed := w.Header().Get(requestIDHeader)
+			if echoed != seen {
+				t.Errorf("echoed %q, handler saw %q", echoed, seen)
+			}
+			if tt.keep && seen != tt.header {
+				t.Errorf("request ID = %q, want %q", seen, tt.header)
+			}
+			if !tt.keep && (seen == tt.header || len(seen) != 32) {
+				t.Errorf("request ID = %q, want a generated one", seen)
+			}
+		})
+	}
+}
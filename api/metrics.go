@@ -0,0 +1,55 @@
+package api
+
+import (
+	"spire-api/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unmatchedRoute labels requests without a route, so scanned paths do not create series
+const unmatchedRoute = "unmatched"
+
+// httpMetrics counts every request by its route template and observes its latency
+func httpMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		metrics.HTTPRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// backendCollector reports the SVID expiry and the managed clusters of each backend when scraped
+type backendCollector struct {
+	backends []*Backend
+}
+
+func (bc backendCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.SVIDExpiryDesc
+	ch <- metrics.ClustersDesc
+}
+
+func (bc backendCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, b := range bc.backends {
+		// no expiry on the admin socket
+		if check := b.Client.CheckX509Source(); check.ExpiresAt != 0 {
+			ch <- prometheus.MustNewConstMetric(metrics.SVIDExpiryDesc, prometheus.GaugeValue, float64(check.ExpiresAt), b.Name)
+		}
+		if n, err := b.Client.CountK8sPsatClusters(b.SpireDir); err == nil {
+			ch <- prometheus.MustNewConstMetric(metrics.ClustersDesc, prometheus.GaugeValue, float64(n), b.Name)
+		}
+	}
+}
+
+// Metrics serves the Prometheus metrics
+func Metrics() gin.HandlerFunc {
+	return gin.WrapH(metrics.Handler())
+}
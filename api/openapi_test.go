@@ -0,0 +1,47 @@
+package api
+
+import (
+	"os"
+	"regexp"
+	grpc "spire-api/spire-grpc"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// TestOpenAPIPaths checks that openapi.yaml documents every route of a backend
+func TestOpenAPIPaths(t *testing.T) {
+	data, err := os.ReadFile("../openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("openapi.yaml: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	sc := &grpc.SPIREClient{Logger: quietLogger()}
+	b := &Backend{Name: "prod", Client: sc, Rotator: grpc.NewRotator(sc)}
+	v1 := router.Group("/v1")
+	registerBackendRoutes(v1, b, nil, nil)
+	registerEntryRoutes(v1, b, nil, nil)
+
+	param := regexp.MustCompile(`:(\w+)`)
+	for _, r := range router.Routes() {
+		path := param.ReplaceAllString(r.Path, "{$1}")
+		ops, ok := spec.Paths[path]
+		if !ok {
+			t.Errorf("%s %s is not in openapi.yaml", r.Method, path)
+			continue
+		}
+		if _, ok := ops[strings.ToLower(r.Method)]; !ok {
+			t.Errorf("%s %s is not in openapi.yaml", r.Method, path)
+		}
+	}
+}
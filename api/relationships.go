@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+// relationshipAuditInput records the relationship with the bundle replaced by its hash
+type relationshipAuditInput struct {
+	TrustDomain       string `json:"trustDomain"`
+	BundleEndpointURL string `json:"bundleEndpointUrl,omitempty"`
+	Profile           string `json:"profile,omitempty"`
+	EndpointSPIFFEID  string `json:"endpointSpiffeId,omitempty"`
+	BundleSHA256      string `json:"bundleSha256,omitempty"`
+}
+
+func newRelationshipAuditInput(fr *grpc.FederationRelationship) relationshipAuditInput {
+	in := relationshipAuditInput{
+		TrustDomain:       fr.TrustDomain,
+		BundleEndpointURL: fr.BundleEndpointURL,
+		Profile:           fr.Profile,
+		EndpointSPIFFEID:  fr.EndpointSPIFFEID,
+	}
+	if fr.TrustDomainBundle != nil {
+		sum := sha256.Sum256([]byte(fr.TrustDomainBundle.Bundle))
+		in.BundleSHA256 = hex.EncodeToString(sum[:])
+	}
+	return in
+}
+
+func writeRelationship(c *gin.Context, r *types.FederationRelationship) {
+	fr, err := grpc.ToFederationRelationship(r)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, fr)
+}
+
+func ListFederationRelationships(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		relationships, err := sc.ListFederationRelationships(c.Request.Context())
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		out := []*grpc.FederationRelationship{}
+		for _, r := range relationships {
+			fr, err := grpc.ToFederationRelationship(r)
+			if err != nil {
+				writeError(c, err)
+				return
+			}
+			out = append(out, fr)
+		}
+		c.IndentedJSON(http.StatusOK, out)
+	}
+}
+
+func GetFederationRelationship(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		r, err := sc.GetFederationRelationship(c.Request.Context(), c.Param("trustDomain"))
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		writeRelationship(c, r)
+	}
+}
+
+// CreateFederationRelationship handles POST requests with a FederationRelationship
+func CreateFederationRelationship(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var fr grpc.FederationRelationship
+		if err := decodeStrict(c, &fr); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		saveRelationship(c, sc, pe, al, "federation.relationship.create", &fr, sc.CreateFederationRelationship)
+	}
+}
+
+// UpdateFederationRelationship handles PUT requests for a trust domain. The endpoint URL and
+// profile are replaced, the bundle only if the request carries one.
+func UpdateFederationRelationship(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var fr grpc.FederationRelationship
+		if err := decodeStrict(c, &fr); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		if fr.TrustDomain != c.Param("trustDomain") {
+			writeValidationError(c, &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "trustDomain", Message: "must match the trust domain in the path"}}})
+			return
+		}
+		saveRelationship(c, sc, pe, al, "federation.relationship.update", &fr, sc.UpdateFederationRelationship)
+	}
+}
+
+func saveRelationship(c *gin.Context, sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log, op string,
+	fr *grpc.FederationRelationship, save func(ctx context.Context, r *types.FederationRelationship) (*types.FederationRelationship, error)) {
+	r, err := sc.ValidateFederationRelationship(fr)
+	if err != nil {
+		writeValidationError(c, err)
+		return
+	}
+	rec := newAuditRecord(c, op)
+	rec.SetInput(newRelationshipAuditInput(fr))
+	defer finishAuditRecord(al, rec, nil)
+
+	if !authorizeAudited(c, pe, policy.VerbFederate, rec) {
+		return
+	}
+	r, err = save(c.Request.Context(), r)
+	if err != nil {
+		rec.Error = err.Error()
+		writeError(c, err)
+		return
+	}
+	writeRelationship(c, r)
+}
+
+// DeleteFederationRelationship handles DELETE requests for a trust domain. The federated
+// bundle is kept, delete it separately under /v1/federation/bundles.
+func DeleteFederationRelationship(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		relationshipAction(c, sc, pe, al, "federation.relationship.delete", sc.DeleteFederationRelationship, "Federation relationship deleted")
+	}
+}
+
+// RefreshFederationRelationship handles POST requests that make the SPIRE server fetch the
+// bundle of a trust domain from its bundle endpoint without waiting for the refresh hint.
+func RefreshFederationRelationship(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		relationshipAction(c, sc, pe, al, "federation.relationship.refresh", sc.RefreshBundle, "Bundle refreshed")
+	}
+}
+
+func relationshipAction(c *gin.Context, sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log, op string, action func(ctx context.Context, td string) error, msg string) {
+	td := c.Param("trustDomain")
+	rec := newAuditRecord(c, op)
+	rec.SetInput(relationshipAuditInput{TrustDomain: td})
+	defer finishAuditRecord(al, rec, nil)
+
+	if !authorizeAudited(c, pe, policy.VerbFederate, rec) {
+		return
+	}
+	if err := action(c.Request.Context(), td); err != nil {
+		rec.Error = err.Error()
+		writeError(c, err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, gin.H{"message": msg, "trustDomain": td})
+}
@@ -1,46 +1,206 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"spire-api/apierror"
+	"spire-api/audit"
+	"spire-api/config"
+	"spire-api/logging"
+	"spire-api/metrics"
+	"spire-api/policy"
 	grpc "spire-api/spire-grpc"
+	"spire-api/tracing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 const (
 	AgentNamespace      = "spire"
 	AgentServiceAccount = "spire-agent"
+
+	traceFlushTimeout = 5 * time.Second
 )
 
-func Start(s string, p int, ap int, sd string, td string, uds string) {
-	logger := logrus.New()
+// Start serves the API until ctx is cancelled. It then stops accepting connections, waits up to
+// the shutdown timeout for in-flight requests and rotations, and closes the SPIRE connections and
+// the audit log. The HTTP server answers 503 until every backend is connected, connecting is
+// retried until ctx is cancelled. cfg must be validated.
+// It returns an error if spire-api could not start or serve, and ErrDrainTimeout if mutations
+// were cut off by the shutdown.
+func Start(ctx context.Context, cfg *config.Config, logger *logrus.Logger) error {
+	// gin's own debug output is not structured, requests are logged by accessLog
+	gin.SetMode(gin.ReleaseMode)
 	logger.Info("Initialize api serverAndPort...")
-	serverAndPort := fmt.Sprintf("%s:%d", s, p)
 
-	spireClient, err := grpc.NewSpireClient(serverAndPort, td, uds)
+	cfgs, err := cfg.BackendConfigs()
+	if err != nil {
+		return fmt.Errorf("failed to load backends: %w", err)
+	}
+	tmpl, err := cfg.AgentConfTemplate()
+	if err != nil {
+		return fmt.Errorf("failed to load agent.conf template: %w", err)
+	}
+
+	pe, err := cfg.PolicyEngine(logger)
+	if err != nil {
+		return fmt.Errorf("failed to load policy: %w", err)
+	}
+
+	al, err := audit.Open(cfg.Audit.Log, logger)
 	if err != nil {
-		logger.Errorf("Failed to connect to SPIRE serverAndPort: %v", err)
-		return
+		return fmt.Errorf("failed to open audit log: %w", err)
 	}
-	defer spireClient.GRPCConn.Close()
-	router := gin.Default()
-	router.GET("/v1/entries", GetEntries(spireClient))
-	router.POST("/v1/entries/add", CreateEntry(spireClient, sd))
-	router.POST("/v1/entries/delete", DeleteEntry(spireClient, sd))
+	defer al.Close()
 
-	if err := router.Run(fmt.Sprintf(":%d", ap)); err != nil {
-		logger.Errorf("Failed to start serverAndPort: %v", err)
-		return
+	stopTracing, err := tracing.Setup(ctx, cfg.Tracing, logger)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer flushTraces(stopTracing, logger)
+
+	ready := newReadiness()
+	handler := &handlerSwitch{}
+	starting, err := startingRouter(ready, cfg.API.TrustedProxies, logger)
+	if err != nil {
+		return err
+	}
+	handler.set(starting)
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.API.Port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	if cfg.API.TLS.Cert != "" {
+		st, err := newServerTLS(cfg.API.TLS, logger)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to load API certificates: %w", err)
+		}
+		ln = tls.NewListener(ln, st.tlsConfig())
+	} else {
+		logger.Warn("No API certificate configured, serving plain HTTP and every caller is anonymous")
+	}
+	srv := &http.Server{Handler: handler}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(ln)
+	}()
+
+	backends, err := openBackends(ctx, cfgs, cfg.ConnectorDefaults(logger), cfg.KubeconfigEncryption())
+	if err != nil {
+		shutdown(srv, cfg.API.ShutdownTimeout)
+		if ctx.Err() != nil {
+			logger.Infof("Stopped while connecting to SPIRE serverAndPort: %v", err)
+			return nil
+		}
+		return fmt.Errorf("failed to connect to SPIRE serverAndPort: %w", err)
+	}
+	defer closeBackends(backends)
+	for _, b := range backends {
+		b.Client.AgentConfTemplate = tmpl
+	}
+	def := defaultBackend(backends)
+	collector := backendCollector{backends: backends}
+	metrics.Registry.MustRegister(collector)
+	defer metrics.Registry.Unregister(collector)
+
+	muts := newMutations()
+	router := gin.New()
+	// the client IP is recorded in the audit log, X-Forwarded-For is only taken from trusted proxies
+	if err := router.SetTrustedProxies(cfg.API.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.Use(requestIDs(), otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(traced)), accessLog(logger), recovery(logger), httpMetrics(), muts.track())
+	router.GET("/healthz", Healthz(backends))
+	router.GET("/metrics", Metrics())
+	router.GET("/readyz", Readyz(ready))
+	router.GET("/bundle.crt", withBackend(def), GetBundlePEM(def.Client))
+
+	v1 := router.Group("/v1")
+	v1.GET("/audit", GetAudit(al, pe))
+	v1.GET("/backends", ListBackends(backends, pe))
+	registerBackendRoutes(v1.Group("", withBackend(def)), def, pe, al)
+	v1.POST("/entries/add", byTrustDomain(backends, func(b *Backend) gin.HandlerFunc {
+		return CreateEntry(b.Client, b.SpireDir, pe, al)
+	}))
+	v1.POST("/entries/delete", byTrustDomain(backends, func(b *Backend) gin.HandlerFunc {
+		return DeleteEntry(b.Client, b.SpireDir, pe, al)
+	}))
+	for _, b := range backends {
+		g := v1.Group("/backends/"+b.Name, withBackend(b))
+		registerBackendRoutes(g, b, pe, al)
+		registerEntryRoutes(g, b, pe, al)
+	}
+	router.NoRoute(func(c *gin.Context) {
+		writeError(c, apierror.New(apierror.NotFound, "no route for %s %s", c.Request.Method, c.Request.URL.Path))
+	})
+
+	handler.set(router)
+	ready.set(stateReady)
+	logger.Info("Connected to SPIRE serverAndPort, ready")
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+	logger.Infof("Shutting down, draining in-flight requests for up to %s...", cfg.API.ShutdownTimeout)
+	ready.set(stateDraining)
+	dctx, cancel := context.WithTimeout(context.Background(), cfg.API.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(dctx); err != nil {
+		logger.Warnf("Requests still running at the shutdown deadline: %v", err)
+	}
+	// rotations write audit records, they are stopped before the audit log is closed
+	for _, b := range backends {
+		b.Rotator.Stop()
+	}
+	if err := muts.wait(dctx); err != nil {
+		return err
 	}
+	logger.Info("Drained, closing SPIRE connections and the audit log")
+	return nil
 }
 
-func GetEntries(sc *grpc.SPIREClient) gin.HandlerFunc {
+// flushTraces exports the spans of the drained requests before exiting
+func flushTraces(stop func(context.Context) error, logger *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := stop(ctx); err != nil {
+		logger.Warnf("Failed to flush traces: %v", err)
+	}
+}
+
+// traced skips the probes and scrapes, they would drown the request traces
+func traced(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/healthz", "/readyz", "/metrics":
+		return false
+	}
+	return true
+}
+
+// shutdown stops accepting connections and waits up to timeout for in-flight requests
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
+
+func GetEntries(sc *grpc.SPIREClient, pe *policy.Engine) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		entries, err := sc.GetEntries()
+		if !authorize(c, pe, policy.VerbRead, nil) {
+			return
+		}
+		entries, err := sc.GetEntries(c.Request.Context())
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 		c.IndentedJSON(http.StatusOK, entries)
@@ -48,100 +208,154 @@ func GetEntries(sc *grpc.SPIREClient) gin.HandlerFunc {
 }
 
 // CreateEntry handles POST requests to add a new SPIRE entry.
-// It parses the incoming JSON payload into a grpc.Entry struct using c.ShouldBindJSON(&e),
-// which binds the request body to the struct and validates it. If binding fails, a 400 error is returned.
-// After successful binding, it sets the SpireDir, creates the entry, and updates K8s configs if needed.
-func CreateEntry(sc *grpc.SPIREClient, sd string) gin.HandlerFunc {
+// It parses the incoming JSON payload into a grpc.Entry struct with bindEntry, which rejects unknown
+// fields and validates names and the trust domain. If validation fails, a 400 error with the invalid fields is returned.
+// After successful binding, the request is checked against the policy engine.
+// It then sets the SpireDir, creates the entry, and updates K8s configs if needed.
+// Every request that reaches the policy check is recorded in the audit log.
+func CreateEntry(sc *grpc.SPIREClient, sd string, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var e *grpc.Entry
-		if err := c.ShouldBindJSON(&e); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		e := bindEntry(c, sc)
+		if e == nil {
 			return
 		}
 		e.SpireDir = sd
-		entryID, err := sc.CreateEntry(e)
+		rec := newAuditRecord(c, "entry.create")
+		rec.SetInput(e.Redacted())
+		before := audit.HashFiles(sc.ConfigFiles(e)...)
+		defer finishAuditRecord(al, rec, before)
+
+		if !authorize(c, pe, policy.VerbCreate, e) {
+			rec.Outcome = audit.OutcomeDenied
+			return
+		}
+		entryID, err := sc.CreateEntry(c.Request.Context(), e)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			rec.Error = err.Error()
+			writeError(c, err)
 			return
 		}
+		rec.EntryIDs = []string{string(*entryID)}
+		metrics.EntriesCreated.WithLabelValues(c.GetString(backendKey), e.Cluster).Inc()
+		var apply, verify func(ctx context.Context, e *grpc.Entry) error
 		if e.KubeConfig != "" {
 			// Update PSAT cluster and Bundle configurations if KubeConfig is provided
-			err = sc.WriteKubeconfig(e)
-			if err != nil {
-				sc.Logger.Errorf("Failed to write kubeconfig: %v", err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+			apply = func(ctx context.Context, e *grpc.Entry) error {
+				if err := tracing.Step(ctx, "kubeconfig.write", func(ctx context.Context) error { return sc.WriteKubeconfig(ctx, e) }); err != nil {
+					logging.From(ctx, sc.Logger).Errorf("Failed to write kubeconfig: %v", err)
+					return err
+				}
+				if err := tracing.Step(ctx, "psat.write", func(ctx context.Context) error { return sc.AddK8sPsat(ctx, e) }); err != nil {
+					logging.From(ctx, sc.Logger).Errorf("Failed to update k8s_psat config: %v", err)
+					return err
+				}
+				// k8s_bundle config is not updated since bundles are pulled over http
+				return nil
 			}
-
-			err = sc.AddK8sPsat(e)
-			if err != nil {
-				sc.Logger.Errorf("Failed to update k8s_psat config: %v", err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+			verify = func(ctx context.Context, e *grpc.Entry) error {
+				return sc.VerifyK8sPsat(ctx, e, true)
 			}
-
-			// Remove bundle config since we'll be using http for bundle pull
-			//err = sc.AddK8sBundle(e)
-			//if err != nil {
-			//	sc.Logger.Errorf("Failed to update k8s_bundle config: %v", err)
-			//	c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			//	return
-			//}
-
 		} else {
-			sc.Logger.Warn("No KubeConfig provided in entry, skipping K8s configuration updates")
+			logging.From(c.Request.Context(), sc.Logger).Warn("No KubeConfig provided in entry, skipping K8s configuration updates")
 		}
 
-		if err := sc.SigUsr1(); err != nil {
-			sc.Logger.Errorf("Failed to send SIGUSR1 to SPIRE serverAndPort: %v", err)
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		replicas, err := sc.ApplyToReplicas(c.Request.Context(), e, apply, verify)
+		recordReplicas(rec, replicas)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, apierror.From(err).WithDetails(gin.H{"entryID": entryID, "replicas": replicas}))
 			return
 		}
-		c.IndentedJSON(http.StatusOK, gin.H{"message": "Entry created", "entryID": entryID})
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Entry created", "entryID": entryID, "replicas": replicas})
 	}
 }
 
-func DeleteEntry(sc *grpc.SPIREClient, sd string) gin.HandlerFunc {
+func DeleteEntry(sc *grpc.SPIREClient, sd string, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var e *grpc.Entry
-		if err := c.ShouldBindJSON(&e); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		e := bindEntry(c, sc)
+		if e == nil {
 			return
 		}
 		e.SpireDir = sd
-		err := sc.DeleteEntryBySPIFFE(e)
+		rec := newAuditRecord(c, "entry.delete")
+		rec.SetInput(e.Redacted())
+		before := audit.HashFiles(sc.ConfigFiles(e)...)
+		defer finishAuditRecord(al, rec, before)
+
+		if !authorize(c, pe, policy.VerbDelete, e) {
+			rec.Outcome = audit.OutcomeDenied
+			return
+		}
+		entryIDs, err := sc.DeleteEntryBySPIFFE(c.Request.Context(), e)
+		rec.EntryIDs = entryIDs
+		metrics.EntriesDeleted.WithLabelValues(c.GetString(backendKey), e.Cluster).Add(float64(len(entryIDs)))
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			rec.Error = err.Error()
+			writeError(c, err)
 			return
 		}
 
 		// If agent is being deleted, remove the associated K8s configurations
+		rec.Reload = audit.ReloadSkipped
+		var replicas []grpc.ReplicaResult
 		if e.ServiceAccount == AgentServiceAccount && e.Namespace == AgentNamespace {
-			if err := sc.DeleteK8sPsat(e); err != nil {
-				sc.Logger.Errorf("Failed to delete k8s_psat config: %v", err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+			apply := func(ctx context.Context, e *grpc.Entry) error {
+				if err := tracing.Step(ctx, "psat.delete", func(ctx context.Context) error { return sc.DeleteK8sPsat(ctx, e) }); err != nil {
+					logging.From(ctx, sc.Logger).Errorf("Failed to delete k8s_psat config: %v", err)
+					return err
+				}
+				// k8s_bundle config is not updated since bundles are pulled over http
+				if err := tracing.Step(ctx, "kubeconfig.delete", func(ctx context.Context) error { return sc.DeleteKubeconfig(ctx, e) }); err != nil {
+					logging.From(ctx, sc.Logger).Errorf("Failed to delete kubeconfig: %v", err)
+					return err
+				}
+				return nil
+			}
+			verify := func(ctx context.Context, e *grpc.Entry) error {
+				return sc.VerifyK8sPsat(ctx, e, false)
 			}
-			// Remove bundle config since we'll be using http for bundle pull
-			//if err := sc.DeleteK8sBundle(e); err != nil {
-			//	sc.Logger.Errorf("Failed to delete k8s_bundle config: %v", err)
-			//	c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			//	return
-			//}
-
-			if err := sc.DeleteKubeconfig(e); err != nil {
-				sc.Logger.Errorf("Failed to delete kubeconfig: %v", err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			replicas, err = sc.ApplyToReplicas(c.Request.Context(), e, apply, verify)
+			recordReplicas(rec, replicas)
+			if err != nil {
+				rec.Error = err.Error()
+				writeError(c, apierror.From(err).WithDetails(gin.H{"replicas": replicas}))
 				return
 			}
 
-			if err := sc.SigUsr1(); err != nil {
-				sc.Logger.Errorf("Failed to send SIGUSR1 to SPIRE serverAndPort: %v", err)
-				c.IndentedJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+			// Offboarding the cluster, evict its agents so they can't keep fetching SVIDs.
+			// The servers were reloaded first, so evicted agents cannot attest again.
+			if e.EvictAgents {
+				var evicted []string
+				err := tracing.Step(c.Request.Context(), "agents.evict", func(ctx context.Context) error {
+					var err error
+					evicted, err = sc.EvictClusterAgents(ctx, e.Cluster)
+					return err
+				})
+				rec.AgentIDs = evicted
+				if err != nil {
+					logging.From(c.Request.Context(), sc.Logger).Errorf("Failed to evict agents of cluster %s: %v", e.Cluster, err)
+					rec.Error = err.Error()
+					writeError(c, err)
+					return
+				}
 			}
 		}
 
-		c.IndentedJSON(http.StatusOK, gin.H{"message": "Entry deleted"})
+		c.IndentedJSON(http.StatusOK, gin.H{"message": "Entry deleted", "replicas": replicas})
+	}
+}
+
+// recordReplicas records the outcome on each replica. The reload is failed if any replica
+// failed, ok if any was reloaded and skipped otherwise.
+func recordReplicas(rec *audit.Record, replicas []grpc.ReplicaResult) {
+	rec.Reload = audit.ReloadSkipped
+	for _, r := range replicas {
+		rec.Replicas = append(rec.Replicas, audit.Replica{Address: r.Address, Written: r.Written, Reload: r.Reload, Error: r.Error})
+		switch {
+		case r.Reload == grpc.ReplicaFailed || !r.Written:
+			rec.Reload = audit.ReloadFailed
+		case r.Reload == grpc.ReplicaOK && rec.Reload != audit.ReloadFailed:
+			rec.Reload = audit.ReloadOK
+		}
 	}
 }
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// failingEntries fails every entry lookup, unset RPCs panic
+type failingEntries struct {
+	entrypb.EntryClient
+}
+
+func (failingEntries) ListEntries(context.Context, *entrypb.ListEntriesRequest, ...ggrpc.CallOption) (*entrypb.ListEntriesResponse, error) {
+	return nil, status.Error(codes.Unavailable, "connection refused")
+}
+
+func TestDeleteEntryLookupFails(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	spireDir := t.TempDir()
+	psat := filepath.Join(spireDir, "k8s_psat.json")
+	const psatConfig = `{"clusters":[{"ambient-a":{"service_account_allow_list":["spire:spire-agent"]}}]}`
+	if err := os.WriteFile(psat, []byte(psatConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sc := &grpc.SPIREClient{Logger: quietLogger(), Client: failingEntries{}, TrustDomain: "example.org"}
+	pe, err := policy.New(&policy.Policy{DefaultEffect: policy.Allow}, quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := audit.Open(filepath.Join(t.TempDir(), "audit.jsonl"), quietLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer al.Close()
+
+	router := gin.New()
+	router.POST("/v1/entries/delete", DeleteEntry(sc, spireDir, pe, al))
+	body := `{"trustDomain":"example.org","namespace":"spire","serviceAccount":"spire-agent","cluster":"ambient-a"}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/entries/delete", strings.NewReader(body)))
+
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"upstream_unavailable"`) {
+		t.Errorf("response = %d %s, want 503 upstream_unavailable", w.Code, w.Body)
+	}
+	if data, err := os.ReadFile(psat); err != nil || string(data) != psatConfig {
+		t.Errorf("k8s_psat config changed after a failed lookup: %s, %v", data, err)
+	}
+	records, err := al.Query(audit.Filter{Operation: "entry.delete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Outcome != audit.OutcomeFailure || records[0].Reload != "" {
+		t.Errorf("audit records = %+v, want one failure without a reload", records)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"spire-api/audit"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+
+	"github.com/gin-gonic/gin"
+)
+
+// svidAuditInput records a mint request. The CSR is recorded by hash and the minted SVID is never recorded.
+type svidAuditInput struct {
+	SpiffeID  string   `json:"spiffeId"`
+	TTL       int32    `json:"ttl"`
+	Audience  []string `json:"audience,omitempty"`
+	CSRSHA256 string   `json:"csrSha256,omitempty"`
+}
+
+// authorizeMint requires a policy rule that lists the mint verb and matches the SPIFFE ID
+func authorizeMint(c *gin.Context, pe *policy.Engine, spiffeID string, rec *audit.Record) bool {
+	if authorizeRequest(c, pe, policy.Request{Caller: callerID(c), Verb: policy.VerbMint, SPIFFEID: spiffeID}) {
+		return true
+	}
+	rec.Outcome = audit.OutcomeDenied
+	return false
+}
+
+// MintX509SVID handles POST requests with a CSR and returns the signed chain. It is meant for
+// systems that cannot run an agent, like the manual spire-server x509 mint flow it replaces.
+func MintX509SVID(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req grpc.X509SVIDRequest
+		if err := decodeStrict(c, &req); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		csr, id, err := sc.ParseX509SVIDRequest(&req)
+		if err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		if req.TTL == 0 {
+			req.TTL = grpc.DefaultX509SVIDTTL
+		}
+		sum := sha256.Sum256(csr)
+		rec := newAuditRecord(c, "svid.x509.mint")
+		rec.SetInput(svidAuditInput{SpiffeID: id.String(), TTL: req.TTL, CSRSHA256: hex.EncodeToString(sum[:])})
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeMint(c, pe, id.String(), rec) {
+			return
+		}
+		svid, err := sc.MintX509SVID(c.Request.Context(), csr, req.TTL)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, svid)
+	}
+}
+
+// MintJWTSVID handles POST requests for a JWT-SVID with the given audiences
+func MintJWTSVID(sc *grpc.SPIREClient, pe *policy.Engine, al *audit.Log) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req grpc.JWTSVIDRequest
+		if err := decodeStrict(c, &req); err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		id, err := sc.ParseJWTSVIDRequest(&req)
+		if err != nil {
+			writeValidationError(c, err)
+			return
+		}
+		if req.TTL == 0 {
+			req.TTL = grpc.DefaultJWTSVIDTTL
+		}
+		rec := newAuditRecord(c, "svid.jwt.mint")
+		rec.SetInput(svidAuditInput{SpiffeID: id.String(), TTL: req.TTL, Audience: req.Audience})
+		defer finishAuditRecord(al, rec, nil)
+
+		if !authorizeMint(c, pe, id.String(), rec) {
+			return
+		}
+		svid, err := sc.MintJWTSVID(c.Request.Context(), id, req.Audience, req.TTL)
+		if err != nil {
+			rec.Error = err.Error()
+			writeError(c, err)
+			return
+		}
+		c.IndentedJSON(http.StatusOK, svid)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"spire-api/config"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// serverTLS holds the API certificate and client CA and reloads them when the files change, so
+// rotated certificates (e.g. SVIDs written by spiffe-helper) are served without a restart.
+type serverTLS struct {
+	files  config.TLSConfig
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	config   *tls.Config
+}
+
+func newServerTLS(files config.TLSConfig, logger *logrus.Logger) (*serverTLS, error) {
+	st := &serverTLS{files: files, logger: logger}
+	if err := st.load(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *serverTLS) paths() []string {
+	paths := []string{st.files.Cert, st.files.Key}
+	if st.files.ClientCA != "" {
+		paths = append(paths, st.files.ClientCA)
+	}
+	return paths
+}
+
+func (st *serverTLS) stat() ([]time.Time, error) {
+	var times []time.Time
+	for _, p := range st.paths() {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, fi.ModTime())
+	}
+	return times, nil
+}
+
+// load reads the files, the current config is kept if any of them is invalid
+func (st *serverTLS) load() error {
+	times, err := st.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(st.files.Cert, st.files.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load API certificate: %v", err)
+	}
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if st.files.ClientCA != "" {
+		pem, err := os.ReadFile(st.files.ClientCA)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates in client CA " + st.files.ClientCA)
+		}
+		// probes and the public bundle are served without a certificate, their callers are anonymous
+		c.ClientCAs = pool
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	st.mu.Lock()
+	st.config, st.modTimes = c, times
+	st.mu.Unlock()
+	return nil
+}
+
+// configForClient reloads changed files before each handshake
+func (st *serverTLS) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	st.mu.Lock()
+	current, times := st.config, st.modTimes
+	st.mu.Unlock()
+	if now, err := st.stat(); err == nil && !equalTimes(now, times) {
+		if err := st.load(); err != nil {
+			// files are rarely written at once, the next change retries
+			st.mu.Lock()
+			st.modTimes = now
+			st.mu.Unlock()
+			st.logger.Warnf("Failed to reload API certificates, keeping the previous ones: %v", err)
+		} else {
+			st.logger.Info("Reloaded API certificates")
+			st.mu.Lock()
+			current = st.config
+			st.mu.Unlock()
+		}
+	}
+	return current, nil
+}
+
+func (st *serverTLS) tlsConfig() *tls.Config {
+	return &tls.Config{GetConfigForClient: st.configForClient}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"spire-api/apierror"
+	grpc "spire-api/spire-grpc"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// decodeStrict decodes the JSON request body into v and rejects unknown fields
+func decodeStrict(c *gin.Context, v any) error {
+	if c.Request.Body == nil {
+		return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "body", Message: "is required"}}}
+	}
+	dec := json.NewDecoder(c.Request.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "body", Message: "is required"}}}
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: strings.Trim(field, `"`), Message: "unknown field"}}}
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: typeErr.Field, Message: "must be a " + typeErr.Type.String()}}}
+		}
+		return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "body", Message: err.Error()}}}
+	}
+	// a null body leaves a pointer target nil, which the validator cannot walk
+	if rv := reflect.ValueOf(v).Elem(); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "body", Message: "is required"}}}
+	}
+	if err := binding.Validator.ValidateStruct(v); err != nil {
+		return bindingFieldErrors(err)
+	}
+	return nil
+}
+
+func init() {
+	// Report validation errors with the JSON field names
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(sf reflect.StructField) string {
+			name := strings.Split(sf.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// bindingFieldErrors converts validator errors to field errors named after the JSON fields
+func bindingFieldErrors(err error) error {
+	var verrs validator.ValidationErrors
+	if !errors.As(err, &verrs) {
+		return &grpc.ValidationError{Fields: []grpc.FieldError{{Field: "body", Message: err.Error()}}}
+	}
+	ve := &grpc.ValidationError{}
+	for _, fe := range verrs {
+		// Namespace is prefixed with the struct name, e.g. Entry.cluster
+		name := fe.Field()
+		if _, field, ok := strings.Cut(fe.Namespace(), "."); ok {
+			name = field
+		}
+		msg := "failed " + fe.Tag() + " validation"
+		if fe.Tag() == "required" {
+			msg = "is required"
+		}
+		ve.Fields = append(ve.Fields, grpc.FieldError{Field: name, Message: msg})
+	}
+	return ve
+}
+
+// bindEntry decodes and validates an entry request. On failure it writes a 400 response with the
+// invalid fields and returns nil.
+func bindEntry(c *gin.Context, sc *grpc.SPIREClient) *grpc.Entry {
+	var e *grpc.Entry
+	err := decodeStrict(c, &e)
+	if err == nil {
+		err = sc.ValidateEntry(e)
+	}
+	if err != nil {
+		writeValidationError(c, err)
+		return nil
+	}
+	return e
+}
+
+// writeValidationError writes err as a validation_failed error, with the invalid fields of a
+// ValidationError in the details
+func writeValidationError(c *gin.Context, err error) {
+	e := apierror.Wrap(apierror.Validation, err)
+	var ve *grpc.ValidationError
+	if errors.As(err, &ve) {
+		e.Message, e.Details = "validation failed", gin.H{"fields": ve.Fields}
+	}
+	c.IndentedJSON(e.Code.HTTPStatus(), e.Body(requestID(c)))
+}
+
+// writeError writes err with the status of its code, see apierror.From. Validation errors are
+// written by writeValidationError.
+func writeError(c *gin.Context, err error) {
+	var ve *grpc.ValidationError
+	if errors.As(err, &ve) {
+		writeValidationError(c, err)
+		return
+	}
+	e := apierror.From(err)
+	c.IndentedJSON(e.Code.HTTPStatus(), e.Body(requestID(c)))
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spire-api/apierror"
+	grpc "spire-api/spire-grpc"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBindEntry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sc := &grpc.SPIREClient{TrustDomain: "example.org"}
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{name: "valid", body: `{"trustDomain":"example.org","namespace":"apps","serviceAccount":"web","cluster":"ambient-a"}`},
+		{name: "no body", body: ``, fields: []string{"body"}},
+		{name: "null body", body: `null`, fields: []string{"body"}},
+		{name: "unknown field", body: `{"trustDomain":"example.org","namespace":"apps","serviceAccount":"web","cluster":"ambient-a","spireDir":"/etc"}`, fields: []string{"spireDir"}},
+		{name: "wrong type", body: `{"trustDomain":"example.org","namespace":"apps","serviceAccount":"web","cluster":"ambient-a","admin":"yes"}`, fields: []string{"admin"}},
+		{name: "missing fields", body: `{"trustDomain":"example.org","namespace":"apps"}`, fields: []string{"serviceAccount", "cluster"}},
+		{name: "path traversal", body: `{"trustDomain":"example.org","namespace":"apps","serviceAccount":"web","cluster":"../kubeconfigs"}`, fields: []string{"cluster"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bound *grpc.Entry
+			router := gin.New()
+			router.POST("/", func(c *gin.Context) { bound = bindEntry(c, sc) })
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body)))
+			if len(tt.fields) == 0 {
+				if bound == nil {
+					t.Errorf("entry not bound: %d %s", w.Code, w.Body)
+				}
+				return
+			}
+			if bound != nil || w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			var body struct {
+				Code    apierror.Code `json:"code"`
+				Details struct {
+					Fields []grpc.FieldError `json:"fields"`
+				} `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range body.Details.Fields {
+				got = append(got, f.Field)
+			}
+			if body.Code != apierror.Validation || strings.Join(got, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("%s with fields %v, want %s with %v", body.Code, got, apierror.Validation, tt.fields)
+			}
+		})
+	}
+}
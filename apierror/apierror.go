@@ -0,0 +1,145 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Code is the stable, machine readable kind of an API error
+type Code string
+
+const (
+	Validation          Code = "validation_failed"
+	NotFound            Code = "not_found"
+	Conflict            Code = "conflict"
+	PermissionDenied    Code = "permission_denied"
+	UpstreamUnavailable Code = "upstream_unavailable"
+	ClientClosedRequest Code = "client_closed_request"
+	ConfigWriteFailed   Code = "config_write_failed"
+	ReloadFailed        Code = "reload_failed"
+	Internal            Code = "internal"
+)
+
+// StatusClientClosedRequest is the non-standard status of requests the client cancelled, as
+// used by nginx. The client never reads it, it keeps cancellations apart in metrics and logs.
+const StatusClientClosedRequest = 499
+
+// HTTPStatus returns the HTTP status of errors with the code
+func (c Code) HTTPStatus() int {
+	switch c {
+	case Validation:
+		return http.StatusBadRequest
+	case NotFound:
+		return http.StatusNotFound
+	case Conflict:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case UpstreamUnavailable:
+		return http.StatusServiceUnavailable
+	case ClientClosedRequest:
+		return StatusClientClosedRequest
+	case ReloadFailed:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is an error with a code. Details are returned to the client as is, Err is the cause
+// and is only used for errors.Is and errors.As.
+type Error struct {
+	Code    Code
+	Message string
+	Details any
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error with code and a formatted message
+func New(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an error with code and the message of err
+func Wrap(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// WithDetails returns a copy of e with details
+func (e *Error) WithDetails(details any) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// From classifies err: an *Error in its chain is returned as is, gRPC status errors of the
+// SPIRE servers and context errors are mapped by their code, anything else is Internal. A
+// cancelled context is the client going away, not the SPIRE server failing.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: ClientClosedRequest, Message: err.Error(), Err: err}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: UpstreamUnavailable, Message: err.Error(), Err: err}
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.Unknown {
+		return &Error{
+			Code:    FromGRPCCode(s.Code()),
+			Message: s.Message(),
+			Details: map[string]string{"grpcCode": s.Code().String()},
+			Err:     err,
+		}
+	}
+	return &Error{Code: Internal, Message: err.Error(), Err: err}
+}
+
+// FromGRPCCode maps the code of a SPIRE server error
+func FromGRPCCode(c codes.Code) Code {
+	switch c {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return Validation
+	case codes.NotFound:
+		return NotFound
+	case codes.AlreadyExists, codes.Aborted, codes.FailedPrecondition:
+		return Conflict
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return PermissionDenied
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return UpstreamUnavailable
+	case codes.Canceled:
+		// the caller's context was cancelled, e.g. the client disconnected
+		return ClientClosedRequest
+	default:
+		return Internal
+	}
+}
+
+// Body is the JSON body of every error response
+type Body struct {
+	Code      Code   `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"requestId"`
+}
+
+// Body returns the response body of e for the request requestID
+func (e *Error) Body(requestID string) Body {
+	return Body{Code: e.Code, Message: e.Message, Details: e.Details, RequestID: requestID}
+}
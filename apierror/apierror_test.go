@@ -0,0 +1,83 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFrom(t *testing.T) {
+	conflict := New(Conflict, "entry exists")
+	tests := []struct {
+		name    string
+		err     error
+		code    Code
+		status  int
+		message string
+	}{
+		{name: "api error", err: conflict, code: Conflict, status: http.StatusConflict, message: "entry exists"},
+		{name: "wrapped api error", err: fmt.Errorf("create: %w", conflict), code: Conflict, status: http.StatusConflict, message: "entry exists"},
+		{name: "deadline", err: fmt.Errorf("list: %w", context.DeadlineExceeded), code: UpstreamUnavailable, status: http.StatusServiceUnavailable, message: "list: context deadline exceeded"},
+		{name: "canceled", err: context.Canceled, code: ClientClosedRequest, status: StatusClientClosedRequest, message: "context canceled"},
+		{name: "grpc canceled", err: status.Error(codes.Canceled, "context canceled"), code: ClientClosedRequest, status: StatusClientClosedRequest, message: "context canceled"},
+		{name: "grpc deadline", err: status.Error(codes.DeadlineExceeded, "context deadline exceeded"), code: UpstreamUnavailable, status: http.StatusServiceUnavailable, message: "context deadline exceeded"},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "bad selector"), code: Validation, status: http.StatusBadRequest, message: "bad selector"},
+		{name: "not found", err: status.Error(codes.NotFound, "no such entry"), code: NotFound, status: http.StatusNotFound, message: "no such entry"},
+		{name: "already exists", err: status.Error(codes.AlreadyExists, "similar entry"), code: Conflict, status: http.StatusConflict, message: "similar entry"},
+		{name: "unauthenticated", err: status.Error(codes.Unauthenticated, "no SVID"), code: PermissionDenied, status: http.StatusForbidden, message: "no SVID"},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), code: UpstreamUnavailable, status: http.StatusServiceUnavailable, message: "connection refused"},
+		{name: "grpc internal", err: status.Error(codes.Internal, "datastore"), code: Internal, status: http.StatusInternalServerError, message: "datastore"},
+		{name: "grpc unknown", err: status.Error(codes.Unknown, "boom"), code: Internal, status: http.StatusInternalServerError, message: "rpc error: code = Unknown desc = boom"},
+		{name: "plain error", err: errors.New("boom"), code: Internal, status: http.StatusInternalServerError, message: "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Code != tt.code || e.Code.HTTPStatus() != tt.status || e.Message != tt.message {
+				t.Errorf("From() = %s %d %q, want %s %d %q", e.Code, e.Code.HTTPStatus(), e.Message, tt.code, tt.status, tt.message)
+			}
+			if !errors.Is(e, tt.err) && !errors.Is(tt.err, e) {
+				t.Errorf("From() lost the cause %v", tt.err)
+			}
+		})
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []struct {
+		code   Code
+		status int
+	}{
+		{Validation, http.StatusBadRequest},
+		{NotFound, http.StatusNotFound},
+		{Conflict, http.StatusConflict},
+		{PermissionDenied, http.StatusForbidden},
+		{UpstreamUnavailable, http.StatusServiceUnavailable},
+		{ClientClosedRequest, StatusClientClosedRequest},
+		{ConfigWriteFailed, http.StatusInternalServerError},
+		{ReloadFailed, http.StatusBadGateway},
+		{Internal, http.StatusInternalServerError},
+		{Code("unknown"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := tt.code.HTTPStatus(); got != tt.status {
+			t.Errorf("%s.HTTPStatus() = %d, want %d", tt.code, got, tt.status)
+		}
+	}
+}
+
+func TestBody(t *testing.T) {
+	orig := New(NotFound, "no entry %s", "abc")
+	b := orig.WithDetails(map[string]string{"id": "abc"}).Body("req-1")
+	if b.Code != NotFound || b.Message != "no entry abc" || b.RequestID != "req-1" || b.Details == nil {
+		t.Errorf("Body() = %+v", b)
+	}
+	if orig.Details != nil {
+		t.Error("WithDetails changed the original error")
+	}
+}
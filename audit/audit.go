@@ -0,0 +1,324 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"spire-api/redact"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+
+	ReloadOK      = "ok"
+	ReloadFailed  = "failed"
+	ReloadSkipped = "skipped"
+
+	// genesisHash is the previous hash of the first record in a log
+	genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+)
+
+// Record is one line of the audit log. Hash covers every other field, including PrevHash,
+// so removing or editing a record breaks the chain from that point on.
+type Record struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Caller    string          `json:"caller"`
+	SourceIP  string          `json:"sourceIp"`
+	RequestID string          `json:"requestId"`
+	Backend   string          `json:"backend,omitempty"`
+	Operation string          `json:"operation"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	EntryIDs  []string        `json:"entryIds,omitempty"`
+	AgentIDs  []string        `json:"agentIds,omitempty"`
+	Files     []FileChange    `json:"files,omitempty"`
+	Reload    string          `json:"reload,omitempty"`
+	Replicas  []Replica       `json:"replicas,omitempty"`
+	Outcome   string          `json:"outcome"`
+	Error     string          `json:"error,omitempty"`
+	PrevHash  string          `json:"prevHash"`
+	Hash      string          `json:"hash"`
+}
+
+// SetInput stores v as the record input. Callers must redact secrets before.
+func (r *Record) SetInput(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	r.Input = data
+}
+
+// Replica is the outcome of a config change on one SPIRE server replica
+type Replica struct {
+	Address string `json:"address"`
+	Written bool   `json:"written"`
+	Reload  string `json:"reload"`
+	Error   string `json:"error,omitempty"`
+}
+
+type FileChange struct {
+	Path   string `json:"path"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	Actor     string
+	Operation string
+	Limit     int
+}
+
+var errLogClosed = errors.New("audit log is closed")
+
+type Log struct {
+	Logger   *logrus.Logger
+	path     string
+	mu       sync.Mutex
+	file     *os.File
+	lastHash string
+	closed   bool
+}
+
+// Open opens the audit log at path for appending and restores the hash chain from the last record.
+// An empty path returns a disabled log that discards records.
+func Open(path string, logger *logrus.Logger) (*Log, error) {
+	al := &Log{
+		Logger:   logger,
+		path:     path,
+		lastHash: genesisHash,
+	}
+	if path == "" {
+		al.Logger.Warn("No audit log configured, mutations will not be audited")
+		return al, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		al.Logger.Errorf("Failed to create audit log dir: %v", err)
+		return nil, err
+	}
+	records, err := al.read()
+	if err != nil {
+		al.Logger.Errorf("Failed to read audit log: %v", err)
+		return nil, err
+	}
+	if err := verify(records); err != nil {
+		// Keep appending so new mutations are still recorded, but make the break visible
+		al.Logger.Errorf("Audit log hash chain is broken: %v", err)
+	}
+	if len(records) > 0 {
+		al.lastHash = records[len(records)-1].Hash
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		al.Logger.Errorf("Failed to open audit log: %v", err)
+		return nil, err
+	}
+	al.file = f
+	al.Logger.Infof("Audit log opened: %s (%d records)", path, len(records))
+	return al, nil
+}
+
+// Close syncs and closes the log, later writes fail instead of being discarded
+func (al *Log) Close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	al.closed = true
+	if al.file == nil {
+		return nil
+	}
+	err := al.file.Sync()
+	if cerr := al.file.Close(); err == nil {
+		err = cerr
+	}
+	al.file = nil
+	return err
+}
+
+// Write chains r to the previous record and appends it to the log.
+func (al *Log) Write(r *Record) error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if al.closed {
+		return errLogClosed
+	}
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now().UTC()
+	}
+	// the ID of a join token agent holds the token, and the log can be queried over the API
+	for i, id := range r.AgentIDs {
+		r.AgentIDs[i] = redact.SPIFFEID(id)
+	}
+	if r.Input != nil {
+		r.Input = json.RawMessage(redact.SPIFFEID(string(r.Input)))
+	}
+	r.Error = redact.SPIFFEID(r.Error)
+	r.PrevHash = al.lastHash
+	hash, err := hashRecord(r)
+	if err != nil {
+		al.Logger.Errorf("Failed to hash audit record: %v", err)
+		return err
+	}
+	r.Hash = hash
+	if al.file == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		al.Logger.Errorf("Failed to marshal audit record: %v", err)
+		return err
+	}
+	if _, err := al.file.Write(append(line, '\n')); err != nil {
+		al.Logger.Errorf("Failed to write audit record: %v", err)
+		return err
+	}
+	if err := al.file.Sync(); err != nil {
+		al.Logger.Errorf("Failed to sync audit log: %v", err)
+		return err
+	}
+	al.lastHash = r.Hash
+	return nil
+}
+
+// Query returns the records matching f, oldest first.
+func (al *Log) Query(f Filter) ([]Record, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	records, err := al.read()
+	if err != nil {
+		return nil, err
+	}
+	var out []Record
+	for _, r := range records {
+		if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && r.Timestamp.After(f.Until) {
+			continue
+		}
+		if f.Actor != "" && r.Caller != f.Actor {
+			continue
+		}
+		if f.Operation != "" && r.Operation != f.Operation {
+			continue
+		}
+		out = append(out, r)
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out, nil
+}
+
+// Verify checks the hash chain of the whole log.
+func (al *Log) Verify() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	records, err := al.read()
+	if err != nil {
+		return err
+	}
+	return verify(records)
+}
+
+func (al *Log) read() ([]Record, error) {
+	if al.path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(al.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("record %d: %v", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+func verify(records []Record) error {
+	prev := genesisHash
+	for i := range records {
+		r := records[i]
+		if r.PrevHash != prev {
+			return fmt.Errorf("record %d: previous hash mismatch", i+1)
+		}
+		hash, err := hashRecord(&r)
+		if err != nil {
+			return err
+		}
+		if hash != r.Hash {
+			return fmt.Errorf("record %d: hash mismatch", i+1)
+		}
+		prev = r.Hash
+	}
+	return nil
+}
+
+func hashRecord(r *Record) (string, error) {
+	c := *r
+	c.Hash = ""
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// HashFiles returns the sha256 of each file, or an empty string if it does not exist.
+func HashFiles(paths ...string) map[string]string {
+	hashes := make(map[string]string, len(paths))
+	for _, p := range paths {
+		hashes[p] = hashFile(p)
+	}
+	return hashes
+}
+
+// Changes compares the current content of the files in before and returns those that changed.
+func Changes(before map[string]string) []FileChange {
+	var changes []FileChange
+	for p, b := range before {
+		a := hashFile(p)
+		if a != b {
+			changes = append(changes, FileChange{Path: p, Before: b, After: a})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func hashFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,167 @@
+package audit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func openTestLog(t *testing.T) (*Log, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	al, err := Open(path, quietLogger())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { al.Close() })
+	return al, path
+}
+
+func TestWriteMasksJoinTokens(t *testing.T) {
+	const token = "3b1f6c2e-8d7a-4d7e-9b1a-2f4c5d6e7f80"
+	agentID := "spiffe://example.org/spire/agent/join_token/" + token
+	al, path := openTestLog(t)
+	rec := &Record{Operation: "agent.ban", AgentIDs: []string{agentID}, Error: "failed to ban agent " + agentID}
+	rec.SetInput(map[string]string{"id": agentID})
+	if err := al.Write(rec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), token) {
+		t.Errorf("audit log holds the join token: %s", data)
+	}
+	if err := al.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
+
+// writeRecords writes n records and returns the lines of the log
+func writeRecords(t *testing.T, al *Log, path string, n int) []string {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		rec := &Record{Caller: "spiffe://example.org/ci", Operation: "entry.create", EntryIDs: []string{fmt.Sprintf("entry-%d", i)}, Outcome: OutcomeSuccess}
+		if err := al.Write(rec); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		err    string
+	}{
+		{name: "untouched", tamper: func(lines []string) []string { return lines }},
+		{name: "edited record", tamper: func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "entry-1", "entry-9", 1)
+			return lines
+		}, err: "record 2: hash mismatch"},
+		{name: "removed record", tamper: func(lines []string) []string {
+			return append(lines[:1:1], lines[2:]...)
+		}, err: "record 2: previous hash mismatch"},
+		{name: "reordered records", tamper: func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, err: "record 2: previous hash mismatch"},
+		{name: "truncated head", tamper: func(lines []string) []string {
+			return lines[1:]
+		}, err: "record 1: previous hash mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al, path := openTestLog(t)
+			lines := tt.tamper(writeRecords(t, al, path, 3))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			err := al.Verify()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Verify() = %v", err)
+			case tt.err != "" && (err == nil || err.Error() != tt.err):
+				t.Errorf("Verify() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReopenContinuesChain(t *testing.T) {
+	al, path := openTestLog(t)
+	writeRecords(t, al, path, 2)
+	al.Close()
+
+	reopened, err := Open(path, quietLogger())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+	lines := writeRecords(t, reopened, path, 1)
+	if len(lines) != 3 {
+		t.Fatalf("%d records, want 3", len(lines))
+	}
+	if err := reopened.Verify(); err != nil {
+		t.Errorf("Verify after reopening: %v", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	al, _ := openTestLog(t)
+	start := time.Now().UTC()
+	for _, r := range []*Record{
+		{Timestamp: start, Caller: "spiffe://example.org/ci", Operation: "entry.create"},
+		{Timestamp: start.Add(time.Minute), Caller: "spiffe://example.org/ops", Operation: "entry.delete"},
+		{Timestamp: start.Add(2 * time.Minute), Caller: "spiffe://example.org/ci", Operation: "entry.delete"},
+	} {
+		if err := al.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all", want: []string{"entry.create", "entry.delete", "entry.delete"}},
+		{name: "actor", filter: Filter{Actor: "spiffe://example.org/ci"}, want: []string{"entry.create", "entry.delete"}},
+		{name: "operation", filter: Filter{Operation: "entry.delete"}, want: []string{"entry.delete", "entry.delete"}},
+		{name: "since", filter: Filter{Since: start.Add(time.Minute)}, want: []string{"entry.delete", "entry.delete"}},
+		{name: "until", filter: Filter{Until: start.Add(time.Minute)}, want: []string{"entry.create", "entry.delete"}},
+		{name: "limit keeps the newest", filter: Filter{Limit: 1}, want: []string{"entry.delete"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := al.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range records {
+				got = append(got, r.Operation)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
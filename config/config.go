@@ -0,0 +1,345 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"spire-api/logging"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"spire-api/tracing"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultBackend is the name of the backend built from the server settings
+const DefaultBackend = "default"
+
+// Config is the configuration of spire-api. It is read from a YAML or TOML file, then
+// SPIRE_API_* environment variables and flags override single settings, see Load.
+type Config struct {
+	Log        logging.Config   `json:"log" yaml:"log"`
+	API        APIConfig        `json:"api" yaml:"api"`
+	Server     ServerConfig     `json:"server" yaml:"server"`
+	Connection ConnectionConfig `json:"connection" yaml:"connection"`
+	// Backends replace Server to manage several SPIRE servers, BackendsFile reads them from a file
+	Backends     []grpc.BackendConfig `json:"backends,omitempty" yaml:"backends,omitempty"`
+	BackendsFile string               `json:"backendsFile,omitempty" yaml:"backendsFile,omitempty"`
+	Auth         AuthConfig           `json:"auth" yaml:"auth"`
+	Audit        AuditConfig          `json:"audit" yaml:"audit"`
+	Kubeconfig   KubeconfigConfig     `json:"kubeconfig" yaml:"kubeconfig"`
+	Templates    TemplatesConfig      `json:"templates" yaml:"templates"`
+	Tracing      tracing.Config       `json:"tracing" yaml:"tracing"`
+}
+
+type APIConfig struct {
+	Port int `json:"port" yaml:"port"`
+	// ShutdownTimeout bounds the drain of in-flight requests on SIGTERM
+	ShutdownTimeout time.Duration `json:"shutdownTimeout" yaml:"shutdownTimeout"`
+	// TLS serves the API over TLS, with ClientCA callers are identified by their client certificate
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// TrustedProxies are the IPs or CIDRs whose X-Forwarded-For is trusted for the client IP,
+	// none by default
+	TrustedProxies []string `json:"trustedProxies,omitempty" yaml:"trustedProxies,omitempty"`
+}
+
+// TLSConfig is the certificate of the API and the CA verifying client certificates. The files
+// are reloaded when they change.
+type TLSConfig struct {
+	Cert     string `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Key      string `json:"key,omitempty" yaml:"key,omitempty"`
+	ClientCA string `json:"clientCA,omitempty" yaml:"clientCA,omitempty"`
+}
+
+// ServerConfig is the single SPIRE server managed without backends. Several addresses are the
+// replicas of an HA deployment, Port applies to addresses without one.
+type ServerConfig struct {
+	Addresses   []string          `json:"addresses,omitempty" yaml:"addresses,omitempty"`
+	Port        int               `json:"port" yaml:"port"`
+	TrustDomain string            `json:"trustDomain,omitempty" yaml:"trustDomain,omitempty"`
+	SpireDir    string            `json:"spireDir" yaml:"spireDir"`
+	Reload      grpc.ReloadConfig `json:"reload,omitempty" yaml:"reload,omitempty"`
+}
+
+// ConnectionConfig is how the SPIRE servers are reached, backends can override each setting
+type ConnectionConfig struct {
+	Mode        string         `json:"mode" yaml:"mode"`
+	UDSPath     string         `json:"udsPath" yaml:"udsPath"`
+	AdminSocket string         `json:"adminSocket" yaml:"adminSocket"`
+	Certs       grpc.CertFiles `json:"certs" yaml:"certs"`
+	Timeouts    grpc.Timeouts  `json:"timeouts" yaml:"timeouts"`
+}
+
+// AuthConfig is the authorization policy, from PolicyFile or inline. Without either every
+// request is allowed.
+type AuthConfig struct {
+	PolicyFile string         `json:"policyFile,omitempty" yaml:"policyFile,omitempty"`
+	Policy     *policy.Policy `json:"policy,omitempty" yaml:"policy,omitempty"`
+}
+
+type AuditConfig struct {
+	// Log is the append-only audit log, empty to disable
+	Log string `json:"log" yaml:"log"`
+}
+
+type KubeconfigConfig struct {
+	KeyFile     string   `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	AgeIdentity string   `json:"ageIdentity,omitempty" yaml:"ageIdentity,omitempty"`
+	OldKeys     []string `json:"oldKeys,omitempty" yaml:"oldKeys,omitempty"`
+	RuntimeDir  string   `json:"runtimeDir" yaml:"runtimeDir"`
+}
+
+type TemplatesConfig struct {
+	// AgentConf replaces the agent.conf returned with join tokens
+	AgentConf string `json:"agentConf,omitempty" yaml:"agentConf,omitempty"`
+}
+
+// Default returns the settings used where neither the file, the environment nor a flag sets one.
+// There is no default SPIRE server or trust domain.
+func Default() *Config {
+	return &Config{
+		Log: logging.Config{
+			Level:  "info",
+			Format: logging.FormatJSON,
+		},
+		API: APIConfig{
+			Port:            8080,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Server: ServerConfig{
+			Port:     8081,
+			SpireDir: "/opt/spire",
+		},
+		Connection: ConnectionConfig{
+			Mode:        grpc.ModeWorkloadAPI,
+			UDSPath:     "/run/spire/sockets/api.sock",
+			AdminSocket: grpc.DefaultAdminSocket,
+			Certs:       grpc.CertFiles{Cert: grpc.CERT, Key: grpc.KEY, CA: grpc.CA},
+			Timeouts:    grpc.Timeouts{Read: grpc.DefaultReadTimeout, Write: grpc.DefaultWriteTimeout},
+		},
+		Audit: AuditConfig{
+			Log: "/var/log/spire-api/audit.jsonl",
+		},
+		Kubeconfig: KubeconfigConfig{
+			RuntimeDir: "/run/spire-api/kubeconfigs",
+		},
+		Tracing: tracing.Config{
+			Exporter:    tracing.ExporterNone,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// ReadFile decodes a YAML, JSON or TOML (by the .toml extension) file over c. Unknown keys are errors.
+func (c *Config) ReadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		// TOML is converted to YAML so both formats share the yaml tags and duration strings
+		var doc map[string]any
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+// YAML returns the effective configuration
+func (c *Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	return string(out), err
+}
+
+// Validate checks the configuration and returns every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	if err := c.Log.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("log.%v", err))
+	}
+	check(c.API.Port > 0 && c.API.Port < 65536, "api.port: %d is not a port", c.API.Port)
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port: %d is not a port", c.Server.Port)
+	check(c.API.ShutdownTimeout > 0, "api.shutdownTimeout: must be positive")
+	check((c.API.TLS.Cert == "") == (c.API.TLS.Key == ""), "api.tls: cert and key must be set together")
+	check(c.API.TLS.ClientCA == "" || c.API.TLS.Cert != "", "api.tls.clientCA: requires cert and key")
+	for _, p := range c.API.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(p)
+		check(cidrErr == nil || net.ParseIP(p) != nil, "api.trustedProxies: %q is not an IP or CIDR", p)
+	}
+	switch c.Connection.Mode {
+	case grpc.ModeWorkloadAPI, grpc.ModeAdminSocket, grpc.ModeStaticCerts:
+	default:
+		errs = append(errs, fmt.Errorf("connection.mode: %q must be one of workload, admin, static", c.Connection.Mode))
+	}
+	check(c.Connection.Timeouts.Read >= 0 && c.Connection.Timeouts.Write >= 0, "connection.timeouts: must not be negative")
+
+	sources := 0
+	for _, set := range []bool{c.serverSet(), len(c.Backends) > 0, c.BackendsFile != ""} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		errs = append(errs, errors.New("no SPIRE server: set server.addresses and server.trustDomain, backends or backendsFile"))
+	case sources > 1:
+		errs = append(errs, errors.New("only one of server, backends and backendsFile can be set"))
+	default:
+		if c.serverSet() {
+			if _, err := spiffeid.TrustDomainFromString(c.Server.TrustDomain); err != nil {
+				errs = append(errs, fmt.Errorf("server.trustDomain: %v", err))
+			}
+		}
+		if _, err := c.BackendConfigs(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(c.Auth.PolicyFile == "" || c.Auth.Policy == nil, "auth: only one of policyFile and policy can be set")
+	if c.Auth.Policy != nil {
+		// validated on a copy, the engine fills in defaults
+		p := *c.Auth.Policy
+		quiet := logrus.New()
+		quiet.SetOutput(io.Discard)
+		pe, err := policy.New(&p, quiet)
+		if err == nil {
+			err = c.checkCallers(pe)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("auth.policy: %v", err))
+		}
+	}
+	check(c.Kubeconfig.KeyFile == "" || c.Kubeconfig.AgeIdentity == "", "kubeconfig: only one of keyFile and ageIdentity can be set")
+	if c.Kubeconfig.KeyFile != "" || c.Kubeconfig.AgeIdentity != "" {
+		check(c.Kubeconfig.RuntimeDir != "", "kubeconfig.runtimeDir: required for encrypted kubeconfigs")
+	}
+	if _, err := c.AgentConfTemplate(); err != nil {
+		errs = append(errs, fmt.Errorf("templates.agentConf: %v", err))
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("tracing: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// serverSet reports whether the single server settings are used. In admin mode the server is
+// reached over its socket, so a trust domain is enough.
+func (c *Config) serverSet() bool {
+	return len(c.Server.Addresses) > 0 || c.Server.TrustDomain != ""
+}
+
+// BackendConfigs returns the validated backends: those of the backends file, the inline
+// backends, or a single backend named DefaultBackend built from the server settings.
+func (c *Config) BackendConfigs() ([]grpc.BackendConfig, error) {
+	if c.BackendsFile != "" {
+		return grpc.LoadBackends(c.BackendsFile)
+	}
+	backends := c.Backends
+	if len(backends) == 0 {
+		b := grpc.BackendConfig{
+			Name:        DefaultBackend,
+			TrustDomain: c.Server.TrustDomain,
+			SpireDir:    c.Server.SpireDir,
+			Mode:        c.Connection.Mode,
+			AdminSocket: c.Connection.AdminSocket,
+			Reload:      c.Server.Reload,
+			Default:     true,
+		}
+		for _, host := range c.Server.Addresses {
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = fmt.Sprintf("%s:%d", host, c.Server.Port)
+			}
+			b.Servers = append(b.Servers, host)
+		}
+		backends = []grpc.BackendConfig{b}
+	}
+	backends, err := grpc.ValidateBackends(append([]grpc.BackendConfig(nil), backends...))
+	if err != nil {
+		return nil, fmt.Errorf("backends: %v", err)
+	}
+	return backends, nil
+}
+
+// ConnectorDefaults are the connection settings for backends that set none
+func (c *Config) ConnectorDefaults(logger *logrus.Logger) grpc.ConnectorDefaults {
+	return grpc.ConnectorDefaults{
+		UDSPath:  c.Connection.UDSPath,
+		Certs:    c.Connection.Certs,
+		Timeouts: c.Connection.Timeouts,
+		Logger:   logger,
+	}
+}
+
+func (c *Config) KubeconfigEncryption() grpc.KubeconfigEncryption {
+	return grpc.KubeconfigEncryption{
+		KeyFile:         c.Kubeconfig.KeyFile,
+		AgeIdentityFile: c.Kubeconfig.AgeIdentity,
+		OldKeyFiles:     c.Kubeconfig.OldKeys,
+		RuntimeDir:      c.Kubeconfig.RuntimeDir,
+	}
+}
+
+// PolicyEngine loads the inline policy or the policy file
+func (c *Config) PolicyEngine(logger *logrus.Logger) (*policy.Engine, error) {
+	var pe *policy.Engine
+	var err error
+	if c.Auth.Policy != nil {
+		p := *c.Auth.Policy
+		pe, err = policy.New(&p, logger)
+	} else {
+		pe, err = policy.Load(c.Auth.PolicyFile, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkCallers(pe); err != nil {
+		return nil, err
+	}
+	return pe, nil
+}
+
+// checkCallers refuses rules matching callers that cannot be identified. Without a client CA
+// every caller is anonymous, so such rules would never match.
+func (c *Config) checkCallers(pe *policy.Engine) error {
+	if rules := pe.CallerRules(); len(rules) > 0 && c.API.TLS.ClientCA == "" {
+		return fmt.Errorf("rules %s match callers, which needs api.tls.clientCA: without client certificates every caller is %s", strings.Join(rules, ", "), policy.AnonymousCaller)
+	}
+	return nil
+}
+
+// Logger returns the logger shared by every part of spire-api
+func (c *Config) Logger() (*logrus.Logger, error) {
+	return logging.New(c.Log)
+}
+
+// AgentConfTemplate parses the agent.conf template, nil for the default
+func (c *Config) AgentConfTemplate() (*template.Template, error) {
+	if c.Templates.AgentConf == "" {
+		return nil, nil
+	}
+	return grpc.ParseAgentConfTemplate(c.Templates.AgentConf)
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"spire-api/policy"
+	grpc "spire-api/spire-grpc"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCallerRulesNeedClientCA(t *testing.T) {
+	callerPolicy := &policy.Policy{Rules: []policy.Rule{
+		{Name: "platform", Effect: policy.Allow, Callers: []string{"spiffe://example.org/platform/*"}},
+	}}
+	tests := []struct {
+		name    string
+		policy  *policy.Policy
+		tls     TLSConfig
+		wantErr string
+	}{
+		{name: "no policy"},
+		{name: "policy without callers", policy: &policy.Policy{Rules: []policy.Rule{{Name: "read", Effect: policy.Allow, Verbs: []string{"read"}}}}},
+		{name: "caller rules without client CA", policy: callerPolicy, wantErr: "needs api.tls.clientCA"},
+		{name: "caller rules with client CA", policy: callerPolicy, tls: TLSConfig{Cert: "api.crt", Key: "api.key", ClientCA: "ca.crt"}},
+		{name: "client CA without cert", tls: TLSConfig{ClientCA: "ca.crt"}, wantErr: "api.tls.clientCA: requires cert and key"},
+		{name: "cert without key", tls: TLSConfig{Cert: "api.crt"}, wantErr: "cert and key must be set together"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Server.Addresses = []string{"spire-server.example.org"}
+			c.Server.TrustDomain = "example.org"
+			c.Auth.Policy = tt.policy
+			c.API.TLS = tt.tls
+			err := c.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte("server:\n  trustDomain: file.example.org\n  spireDir: /opt/spire-file\napi:\n  port: 9090\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		env         map[string]string
+		args        []string
+		trustDomain string
+		port        int
+		spireDir    string
+	}{
+		{name: "defaults", trustDomain: "", port: Default().API.Port, spireDir: Default().Server.SpireDir},
+		{name: "file over defaults", args: []string{"-config", file}, trustDomain: "file.example.org", port: 9090, spireDir: "/opt/spire-file"},
+		{name: "config file from the environment", env: map[string]string{"SPIRE_API_CONFIG": file}, trustDomain: "file.example.org", port: 9090, spireDir: "/opt/spire-file"},
+		{
+			name:        "environment over file",
+			env:         map[string]string{"SPIRE_API_TRUST_DOMAIN": "env.example.org"},
+			args:        []string{"-config", file},
+			trustDomain: "env.example.org", port: 9090, spireDir: "/opt/spire-file",
+		},
+		{
+			name:        "flag over environment",
+			env:         map[string]string{"SPIRE_API_TRUST_DOMAIN": "env.example.org", "SPIRE_API_API_PORT": "7070"},
+			args:        []string{"-config", file, "-trust-domain", "flag.example.org"},
+			trustDomain: "flag.example.org", port: 7070, spireDir: "/opt/spire-file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			c, _, err := Load("spire-api", tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if c.Server.TrustDomain != tt.trustDomain || c.API.Port != tt.port || c.Server.SpireDir != tt.spireDir {
+				t.Errorf("trustDomain %q, port %d, spireDir %q, want %q, %d, %q",
+					c.Server.TrustDomain, c.API.Port, c.Server.SpireDir, tt.trustDomain, tt.port, tt.spireDir)
+			}
+		})
+	}
+}
+
+func TestSampleFiles(t *testing.T) {
+	c := Default()
+	if err := c.ReadFile("../sample-config.yaml"); err != nil {
+		t.Fatalf("sample-config.yaml: %v", err)
+	}
+	if _, err := c.BackendConfigs(); err != nil {
+		t.Errorf("sample-config.yaml backends: %v", err)
+	}
+	if _, err := grpc.LoadBackends("../sample-backends.yaml"); err != nil {
+		t.Errorf("sample-backends.yaml: %v", err)
+	}
+	if _, err := policy.Load("../sample-policy.yaml", logrus.New()); err != nil {
+		t.Errorf("sample-policy.yaml: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variable of every flag, e.g. SPIRE_API_TRUST_DOMAIN for -trust-domain
+const EnvPrefix = "SPIRE_API_"
+
+// Options are the flags that are not settings
+type Options struct {
+	// ConfigFile is the YAML or TOML config file
+	ConfigFile string
+	// PrintConfig prints the effective configuration and exits
+	PrintConfig bool
+}
+
+// listValue is a comma separated flag, setting it replaces the list
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// bind defines the flags of c's settings on fs, with the current values of c as defaults
+func bind(fs *flag.FlagSet, c *Config, o *Options) {
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, "Path to a YAML or TOML config file")
+	fs.BoolVar(&o.PrintConfig, "print-config", o.PrintConfig, "Print the effective configuration and exit")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "Log level: trace, debug, info, warn or error. debug logs every RPC to the SPIRE servers with its duration")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "Log format: json or text")
+
+	fs.IntVar(&c.API.Port, "api-port", c.API.Port, "API server port")
+	fs.DurationVar(&c.API.ShutdownTimeout, "shutdown-timeout", c.API.ShutdownTimeout, "How long SIGTERM waits for in-flight requests before exiting")
+	fs.StringVar(&c.API.TLS.Cert, "api-cert-file", c.API.TLS.Cert, "Certificate of the API, serves HTTPS when set")
+	fs.StringVar(&c.API.TLS.Key, "api-key-file", c.API.TLS.Key, "Key of the API certificate")
+	fs.Var((*listValue)(&c.API.TrustedProxies), "trusted-proxies", "Comma separated IPs or CIDRs of proxies whose X-Forwarded-For sets the client IP, none by default")
+	fs.StringVar(&c.API.TLS.ClientCA, "api-client-ca-file", c.API.TLS.ClientCA, "CA bundle verifying client certificates, callers are identified by the SPIFFE ID of their certificate")
+
+	fs.Var((*listValue)(&c.Server.Addresses), "server", "SPIRE server address, or a comma separated list of HA replicas")
+	fs.IntVar(&c.Server.Port, "port", c.Server.Port, "SPIRE server port, for addresses without one")
+	fs.StringVar(&c.Server.TrustDomain, "trust-domain", c.Server.TrustDomain, "Trust domain for SPIRE")
+	fs.StringVar(&c.Server.SpireDir, "spire-dir", c.Server.SpireDir, "SPIRE directory path")
+	fs.StringVar(&c.Server.Reload.Strategy, "reload", c.Server.Reload.Strategy, "How the SPIRE server re-reads its config: signal (default), pidfile, exec or none")
+	fs.StringVar(&c.Server.Reload.ProcessName, "reload-process-name", c.Server.Reload.ProcessName, "Process signalled by -reload signal, default spire-server")
+	fs.StringVar(&c.Server.Reload.PIDFile, "reload-pid-file", c.Server.Reload.PIDFile, "PID file of the SPIRE server for -reload pidfile")
+
+	fs.StringVar(&c.Connection.Mode, "connection", c.Connection.Mode, "How to connect to the SPIRE server: workload (mTLS with a Workload API SVID), admin (local admin socket) or static (certificate files)")
+	fs.StringVar(&c.Connection.UDSPath, "uds-path", c.Connection.UDSPath, "Path to the SPIRE API socket")
+	fs.StringVar(&c.Connection.AdminSocket, "admin-socket", c.Connection.AdminSocket, "Path to the SPIRE server admin socket for -connection admin")
+	fs.StringVar(&c.Connection.Certs.Cert, "cert-file", c.Connection.Certs.Cert, "Client certificate for -connection static, reloaded when it changes")
+	fs.StringVar(&c.Connection.Certs.Key, "key-file", c.Connection.Certs.Key, "Client key for -connection static, reloaded when it changes")
+	fs.StringVar(&c.Connection.Certs.CA, "ca-file", c.Connection.Certs.CA, "CA bundle verifying the SPIRE server for -connection static, reloaded when it changes")
+	fs.DurationVar(&c.Connection.Timeouts.Read, "read-timeout", c.Connection.Timeouts.Read, "Timeout of each read RPC to the SPIRE server, reads are retried while the server is unavailable")
+	fs.DurationVar(&c.Connection.Timeouts.Write, "write-timeout", c.Connection.Timeouts.Write, "Timeout of each write RPC to the SPIRE server")
+
+	fs.StringVar(&c.BackendsFile, "backends-file", c.BackendsFile, "Path to a YAML or JSON file of SPIRE backends, replaces the server settings")
+	fs.StringVar(&c.Auth.PolicyFile, "policy-file", c.Auth.PolicyFile, "Path to the authorization policy file (YAML or JSON)")
+	fs.StringVar(&c.Audit.Log, "audit-log", c.Audit.Log, "Path to the append-only audit log, empty to disable")
+
+	fs.StringVar(&c.Kubeconfig.KeyFile, "kubeconfig-key-file", c.Kubeconfig.KeyFile, "Path to a 32 byte AES key used to encrypt stored kubeconfigs")
+	fs.StringVar(&c.Kubeconfig.AgeIdentity, "kubeconfig-age-identity", c.Kubeconfig.AgeIdentity, "Path to an age X25519 identity used to encrypt stored kubeconfigs")
+	fs.Var((*listValue)(&c.Kubeconfig.OldKeys), "kubeconfig-old-keys", "Comma separated previous key or identity files, stored kubeconfigs are re-encrypted with the current key")
+	fs.StringVar(&c.Kubeconfig.RuntimeDir, "kubeconfig-runtime-dir", c.Kubeconfig.RuntimeDir, "Directory (tmpfs) for the decrypted kubeconfigs read by the SPIRE server")
+
+	fs.StringVar(&c.Templates.AgentConf, "agent-conf-template", c.Templates.AgentConf, "Path to a text/template replacing the agent.conf returned with join tokens")
+
+	fs.StringVar(&c.Tracing.Exporter, "tracing-exporter", c.Tracing.Exporter, "Where spans are exported: none, otlp (gRPC) or stdout")
+	fs.StringVar(&c.Tracing.Endpoint, "otlp-endpoint", c.Tracing.Endpoint, "host:port of the OTLP collector, default localhost:4317 or OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.BoolVar(&c.Tracing.Insecure, "otlp-insecure", c.Tracing.Insecure, "Connect to the OTLP collector without TLS")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracing-sample-ratio", c.Tracing.SampleRatio, "Share of new traces that are sampled, between 0 and 1")
+}
+
+// EnvName returns the environment variable of a flag
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from the defaults, then the config file, then the SPIRE_API_*
+// environment variables, then the flags in args. It returns flag.ErrHelp for -h. The result
+// is not validated, see Validate.
+func Load(name string, args []string) (*Config, Options, error) {
+	// the flags are parsed first for the config file, then applied again over it
+	var o Options
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(fs, Default(), &o)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", name)
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\nEvery flag can also be set with an environment variable, e.g. %s for -trust-domain.\n", EnvName("trust-domain"))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, o, err
+	}
+	if fs.NArg() > 0 {
+		return nil, o, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	set := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+	if path, ok := os.LookupEnv(EnvName("config")); ok && set["config"] == "" {
+		o.ConfigFile = path
+	}
+
+	c := Default()
+	if o.ConfigFile != "" {
+		if err := c.ReadFile(o.ConfigFile); err != nil {
+			return nil, o, err
+		}
+	}
+	over := flag.NewFlagSet(name, flag.ContinueOnError)
+	over.SetOutput(io.Discard)
+	bind(over, c, &Options{})
+	var err error
+	over.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		if v, ok := os.LookupEnv(EnvName(f.Name)); ok && err == nil {
+			if serr := over.Set(f.Name, v); serr != nil {
+				err = fmt.Errorf("%s: %v", EnvName(f.Name), serr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, o, err
+	}
+	for n, v := range set {
+		if err := over.Set(n, v); err != nil {
+			return nil, o, fmt.Errorf("-%s: %v", n, err)
+		}
+	}
+	if v, ok := os.LookupEnv(EnvName("print-config")); ok && set["print-config"] == "" {
+		if o.PrintConfig, err = strconv.ParseBool(v); err != nil {
+			return nil, o, fmt.Errorf("%s: %v", EnvName("print-config"), err)
+		}
+	}
+	return c, o, nil
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"spire-api/redact"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// RequestIDField is the field of the request ID on every line logged for a request
+const RequestIDField = "request_id"
+
+type requestIDKey struct{}
+
+// Config is the level and format of the spire-api logger
+type Config struct {
+	// Level is a logrus level: trace, debug, info, warn or error
+	Level string `json:"level" yaml:"level"`
+	// Format is json or text
+	Format string `json:"format" yaml:"format"`
+}
+
+func (c Config) Validate() error {
+	if _, err := logrus.ParseLevel(c.Level); err != nil {
+		return fmt.Errorf("level: %v", err)
+	}
+	if c.Format != FormatJSON && c.Format != FormatText {
+		return fmt.Errorf("format: %q must be json or text", c.Format)
+	}
+	return nil
+}
+
+// New returns the logger shared by every part of spire-api, with the redaction hook installed
+func New(c Config) (*logrus.Logger, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	logger := redact.NewLogger()
+	level, _ := logrus.ParseLevel(c.Level)
+	logger.SetLevel(level)
+	if c.Format == FormatJSON {
+		logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	}
+	return logger, nil
+}
+
+// WithRequestID returns ctx carrying the request ID of the HTTP request it serves
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, empty outside of requests
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// From returns logger with the request ID of ctx, use it for every line logged for a request
+func From(ctx context.Context, logger *logrus.Logger) *logrus.Entry {
+	entry := logger.WithContext(ctx)
+	if id := RequestID(ctx); id != "" {
+		entry = entry.WithField(RequestIDField, id)
+	}
+	return entry
+}
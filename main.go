@@ -1,22 +1,67 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"github.com/sirupsen/logrus"
+	"fmt"
+	"os"
+	"os/signal"
 	server "spire-api/api"
+	"spire-api/config"
+	"syscall"
 )
 
 func main() {
-	//add argument for server address
-	serverAddress := flag.String("server", "omegaspire01.omegaworld.net", "SPIRE server address")
-	serverPort := flag.Int("port", 8081, "SPIRE server port")
-	apiPort := flag.Int("api-port", 8080, "API server port")
-	spireDir := flag.String("spire-dir", "/opt/spire", "SPIRE directory path")
-	trusDomain := flag.String("trust-domain", "wl.dev.omegaworld.net", "Trust domain for SPIRE")
-	udsPath := flag.String("uds-path", "/run/spire/sockets/api.sock", "Path to the SPIRE API socket")
-	flag.Parse()
+	// configuration errors are logged with the default level and format
+	logger, _ := config.Default().Logger()
+	cfg, opts, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Errorf("Invalid configuration: %v", err)
+		os.Exit(exitFailure)
+	}
+	if opts.PrintConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			logger.Errorf("Failed to print configuration: %v", err)
+			os.Exit(exitFailure)
+		}
+		fmt.Print(out)
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Errorf("Invalid configuration: %v", err)
+		os.Exit(exitFailure)
+	}
+	if opts.PrintConfig {
+		return
+	}
+	logger, _ = cfg.Logger()
 
-	logger := logrus.New()
+	// SIGTERM drains in-flight requests before the SPIRE connections are closed
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 	logger.Info("Calling Start...")
-	server.Start(*serverAddress, *serverPort, *apiPort, *spireDir, *trusDomain, *udsPath)
+	err = server.Start(ctx, cfg, logger)
+	stop()
+	if err != nil {
+		logger.Errorf("spire-api failed: %v", err)
+		os.Exit(exitCode(err))
+	}
+	logger.Info("spire-api stopped")
+}
+
+// Exit codes, a supervisor restarting spire-api can tell a failed start from a cut off shutdown
+const (
+	exitFailure      = 1
+	exitDrainTimeout = 2
+)
+
+func exitCode(err error) int {
+	if errors.Is(err, server.ErrDrainTimeout) {
+		return exitDrainTimeout
+	}
+	return exitFailure
 }
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "spire_api"
+
+// Registry holds every spire-api metric, plus the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// HTTP requests by registered route, path parameters are not expanded
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+)
+
+// RPCs to the SPIRE servers, one per call including its retries
+var (
+	GRPCClientRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_requests_total",
+		Help:      "RPCs to SPIRE servers by service, method and gRPC code.",
+	}, []string{"service", "method", "code"})
+	GRPCClientDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_client_request_duration_seconds",
+		Help:      "Latency of RPCs to SPIRE servers by service, method and gRPC code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service", "method", "code"})
+)
+
+var (
+	EntriesCreated = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_created_total",
+		Help:      "Entries created by backend and cluster.",
+	}, []string{"backend", "cluster"})
+	EntriesDeleted = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_deleted_total",
+		Help:      "Entries deleted by backend and cluster.",
+	}, []string{"backend", "cluster"})
+	PSATConfigWriteFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "psat_config_write_failures_total",
+		Help:      "Failed updates of the k8s_psat config by backend and operation (add or delete).",
+	}, []string{"backend", "operation"})
+)
+
+// SPIRE server reloads, outcome is ok or failed, a strategy of none is not counted
+var (
+	Reloads = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reloads_total",
+		Help:      "SPIRE server reloads by backend, strategy and outcome.",
+	}, []string{"backend", "strategy", "outcome"})
+	ReloadDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "reload_duration_seconds",
+		Help:      "Duration of SPIRE server reloads by backend and strategy.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30},
+	}, []string{"backend", "strategy"})
+)
+
+// Gauges read when scraped, see Handler
+var (
+	SVIDExpiryDesc = prometheus.NewDesc(namespace+"_svid_expiry_timestamp_seconds",
+		"Expiry of the spire-api SVID or static client certificate by backend, as a Unix timestamp.",
+		[]string{"backend"}, nil)
+	ClustersDesc = prometheus.NewDesc(namespace+"_clusters",
+		"Kubernetes clusters in the k8s_psat config by backend.",
+		[]string{"backend"}, nil)
+)
+
+// Handler serves the metrics of Registry
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
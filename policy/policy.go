@@ -0,0 +1,329 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"spire-api/logging"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// AnonymousCaller is the caller of requests without a client certificate
+const AnonymousCaller = "anonymous"
+
+// Verbs used by the api handlers
+const (
+	VerbRead      = "read"
+	VerbCreate    = "create"
+	VerbDelete    = "delete"
+	VerbAudit     = "audit"
+	VerbBan       = "ban"
+	VerbEvict     = "evict"
+	VerbToken     = "jointoken"
+	VerbFederate  = "federate"
+	VerbMint      = "mint"
+	VerbAuthority = "authority"
+)
+
+// explicitVerbs are only granted by an allow rule that lists the verb by name. Allow rules without
+// verbs or with wildcards do not grant them, neither does the default effect, and they are denied
+// when no policy is configured. Deny rules match them like any other verb.
+var explicitVerbs = map[string]bool{
+	VerbMint: true,
+}
+
+// Flags that can be set on a request
+const (
+	FlagAdmin      = "admin"
+	FlagDownstream = "downstream"
+	FlagKubeConfig = "kubeconfig"
+	FlagEvict      = "evict-agents"
+)
+
+// explicitFlags are only granted by an allow rule that lists the flag by name in flags, like
+// explicitVerbs. Admin and downstream entries are denied without a policy.
+var explicitFlags = map[string]bool{
+	FlagAdmin:      true,
+	FlagDownstream: true,
+}
+
+// Rule matches a request when every non-empty field matches. Patterns support '*' as a wildcard.
+// Flags matches when the request sets at least one of the listed flags.
+// SPIFFEIDs matches the SPIFFE ID of a minted SVID or of a join token entry. Backends matches the name of the SPIRE backend.
+type Rule struct {
+	Name            string   `json:"name" yaml:"name"`
+	Effect          Effect   `json:"effect" yaml:"effect"`
+	Callers         []string `json:"callers,omitempty" yaml:"callers,omitempty"`
+	Verbs           []string `json:"verbs,omitempty" yaml:"verbs,omitempty"`
+	Clusters        []string `json:"clusters,omitempty" yaml:"clusters,omitempty"`
+	Namespaces      []string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	ServiceAccounts []string `json:"serviceAccounts,omitempty" yaml:"serviceAccounts,omitempty"`
+	Flags           []string `json:"flags,omitempty" yaml:"flags,omitempty"`
+	SPIFFEIDs       []string `json:"spiffeIds,omitempty" yaml:"spiffeIds,omitempty"`
+	Backends        []string `json:"backends,omitempty" yaml:"backends,omitempty"`
+}
+
+// Policy is the on-disk format. Rules are evaluated in order and the first match wins.
+// If no rule matches, DefaultEffect applies (deny when unset).
+type Policy struct {
+	DefaultEffect Effect `json:"defaultEffect,omitempty" yaml:"defaultEffect,omitempty"`
+	Rules         []Rule `json:"rules" yaml:"rules"`
+}
+
+type Request struct {
+	Caller         string
+	Verb           string
+	Cluster        string
+	Namespace      string
+	ServiceAccount string
+	Flags          []string
+	SPIFFEID       string
+	Backend        string
+}
+
+type Decision struct {
+	Allowed bool
+	Rule    string
+	Reason  string
+}
+
+type Engine struct {
+	Logger   *logrus.Logger
+	policy   *Policy
+	patterns map[string]*regexp.Regexp
+}
+
+// New returns an engine for a policy given inline, e.g. in the spire-api config file
+func New(p *Policy, logger *logrus.Logger) (*Engine, error) {
+	pe := &Engine{
+		Logger:   logger,
+		patterns: map[string]*regexp.Regexp{},
+	}
+	if err := pe.setPolicy(p); err != nil {
+		return nil, err
+	}
+	pe.Logger.Infof("Loaded %d inline policy rules", len(p.Rules))
+	return pe, nil
+}
+
+// Load reads a policy from a YAML or JSON file. An empty path returns an engine that allows
+// every request, which keeps the behaviour of an API without a policy file.
+func Load(path string, logger *logrus.Logger) (*Engine, error) {
+	pe := &Engine{
+		Logger:   logger,
+		patterns: map[string]*regexp.Regexp{},
+	}
+	if path == "" {
+		pe.Logger.Warn("No policy file configured, all requests will be allowed")
+		return pe, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		pe.Logger.Errorf("Failed to read policy file: %v", err)
+		return nil, err
+	}
+	// JSON is valid YAML, so one decoder covers both formats
+	p := &Policy{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		pe.Logger.Errorf("Failed to parse policy file: %v", err)
+		return nil, err
+	}
+	if err := pe.setPolicy(p); err != nil {
+		return nil, err
+	}
+	pe.Logger.Infof("Loaded %d policy rules from %s", len(p.Rules), path)
+	return pe, nil
+}
+
+func (pe *Engine) setPolicy(p *Policy) error {
+	if p.DefaultEffect == "" {
+		p.DefaultEffect = Deny
+	}
+	if p.DefaultEffect != Allow && p.DefaultEffect != Deny {
+		return fmt.Errorf("invalid default effect %q", p.DefaultEffect)
+	}
+	for i, r := range p.Rules {
+		if r.Effect != Allow && r.Effect != Deny {
+			return fmt.Errorf("rule %d (%s): invalid effect %q", i, r.Name, r.Effect)
+		}
+		for _, list := range [][]string{r.Callers, r.Verbs, r.Clusters, r.Namespaces, r.ServiceAccounts, r.Flags, r.SPIFFEIDs, r.Backends} {
+			for _, pattern := range list {
+				if _, ok := pe.patterns[pattern]; ok {
+					continue
+				}
+				re, err := compile(pattern)
+				if err != nil {
+					return fmt.Errorf("rule %d (%s): %v", i, r.Name, err)
+				}
+				pe.patterns[pattern] = re
+			}
+		}
+	}
+	pe.policy = p
+	return nil
+}
+
+// CallerRules returns the names of the rules with callers patterns that only match identified callers
+func (pe *Engine) CallerRules() []string {
+	if pe.policy == nil {
+		return nil
+	}
+	var names []string
+	for i, r := range pe.policy.Rules {
+		for _, p := range r.Callers {
+			if !pe.patterns[p].MatchString(AnonymousCaller) {
+				name := r.Name
+				if name == "" {
+					name = fmt.Sprintf("%d", i)
+				}
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// Evaluate returns the decision for req and logs it with the request ID of ctx.
+func (pe *Engine) Evaluate(ctx context.Context, req Request) Decision {
+	d := pe.decide(req)
+	fields := logrus.Fields{
+		"caller":         req.Caller,
+		"verb":           req.Verb,
+		"cluster":        req.Cluster,
+		"namespace":      req.Namespace,
+		"serviceAccount": req.ServiceAccount,
+		"flags":          req.Flags,
+		"spiffeId":       req.SPIFFEID,
+		"backend":        req.Backend,
+		"allowed":        d.Allowed,
+		"rule":           d.Rule,
+	}
+	if d.Allowed {
+		logging.From(ctx, pe.Logger).WithFields(fields).Info("Policy decision: allow")
+	} else {
+		logging.From(ctx, pe.Logger).WithFields(fields).Warnf("Policy decision: deny (%s)", d.Reason)
+	}
+	return d
+}
+
+func (pe *Engine) decide(req Request) Decision {
+	explicit := explicitGrants(req)
+	if pe.policy == nil {
+		if len(explicit) > 0 {
+			return Decision{Reason: fmt.Sprintf("%s requires a policy rule", strings.Join(explicit, ", "))}
+		}
+		return Decision{Allowed: true, Reason: "no policy configured"}
+	}
+	for _, r := range pe.policy.Rules {
+		if !pe.matches(r, req) {
+			continue
+		}
+		// deny rules stop explicit verbs and flags like any other, only allow rules have to name them
+		if r.Effect == Allow && !grants(r, req) {
+			continue
+		}
+		return Decision{
+			Allowed: r.Effect == Allow,
+			Rule:    r.Name,
+			Reason:  fmt.Sprintf("matched rule %q", r.Name),
+		}
+	}
+	if len(explicit) > 0 {
+		return Decision{Reason: fmt.Sprintf("%s requires a policy rule", strings.Join(explicit, ", "))}
+	}
+	return Decision{
+		Allowed: pe.policy.DefaultEffect == Allow,
+		Reason:  "no rule matched",
+	}
+}
+
+// explicitGrants returns the explicit verb and flags of req, e.g. `verb "mint"`
+func explicitGrants(req Request) []string {
+	var explicit []string
+	if explicitVerbs[req.Verb] {
+		explicit = append(explicit, fmt.Sprintf("verb %q", req.Verb))
+	}
+	for _, f := range req.Flags {
+		if explicitFlags[f] {
+			explicit = append(explicit, fmt.Sprintf("flag %q", f))
+		}
+	}
+	return explicit
+}
+
+// grants reports whether r names the explicit verb and every explicit flag of req
+func grants(r Rule, req Request) bool {
+	if explicitVerbs[req.Verb] && !contains(r.Verbs, req.Verb) {
+		return false
+	}
+	for _, f := range req.Flags {
+		if explicitFlags[f] && !contains(r.Flags, f) {
+			return false
+		}
+	}
+	return true
+}
+
+func (pe *Engine) matches(r Rule, req Request) bool {
+	if !pe.matchAny(r.Callers, req.Caller) ||
+		!pe.matchAny(r.Verbs, req.Verb) ||
+		!pe.matchAny(r.Clusters, req.Cluster) ||
+		!pe.matchAny(r.Namespaces, req.Namespace) ||
+		!pe.matchAny(r.ServiceAccounts, req.ServiceAccount) ||
+		!pe.matchAny(r.SPIFFEIDs, req.SPIFFEID) ||
+		!pe.matchAny(r.Backends, req.Backend) {
+		return false
+	}
+	if len(r.Flags) == 0 {
+		return true
+	}
+	for _, f := range req.Flags {
+		if pe.matchAny(r.Flags, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether list has s itself, wildcards do not count
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny returns true if patterns is empty or any pattern matches s
+func (pe *Engine) matchAny(patterns []string, s string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if pe.patterns[p].MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
@@ -0,0 +1,235 @@
+package policy
+
+import (
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func quietLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(io.Discard)
+	return l
+}
+
+func newEngine(t *testing.T, p *Policy) *Engine {
+	t.Helper()
+	pe, err := New(p, quietLogger())
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return pe
+}
+
+func TestExplicitVerbs(t *testing.T) {
+	const (
+		platform    = "spiffe://example.org/platform/ci"
+		compromised = "spiffe://example.org/platform/compromised"
+		legacyID    = "spiffe://example.org/legacy/app"
+	)
+	grantMint := Rule{Name: "mint", Effect: Allow, Callers: []string{"spiffe://example.org/platform/*"}, Verbs: []string{VerbMint}, SPIFFEIDs: []string{"spiffe://example.org/legacy/*"}}
+	tests := []struct {
+		name   string
+		policy *Policy
+		req    Request
+		allow  bool
+	}{
+		{
+			name:  "no policy denies mint",
+			req:   Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow: false,
+		},
+		{
+			name:  "no policy allows other verbs",
+			req:   Request{Caller: platform, Verb: VerbCreate},
+			allow: true,
+		},
+		{
+			name:   "rule listing mint grants it",
+			policy: &Policy{Rules: []Rule{grantMint}},
+			req:    Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow:  true,
+		},
+		{
+			name:   "allow rule without verbs does not grant mint",
+			policy: &Policy{Rules: []Rule{{Name: "all", Effect: Allow, Callers: []string{"*"}}}},
+			req:    Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow:  false,
+		},
+		{
+			name:   "wildcard verb does not grant mint",
+			policy: &Policy{Rules: []Rule{{Name: "all", Effect: Allow, Verbs: []string{"*"}}}},
+			req:    Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow:  false,
+		},
+		{
+			name:   "default allow does not grant mint",
+			policy: &Policy{DefaultEffect: Allow},
+			req:    Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow:  false,
+		},
+		{
+			name: "broad deny stops mint before a later grant",
+			policy: &Policy{Rules: []Rule{
+				{Name: "compromised", Effect: Deny, Callers: []string{compromised}},
+				grantMint,
+			}},
+			req:   Request{Caller: compromised, Verb: VerbMint, SPIFFEID: legacyID},
+			allow: false,
+		},
+		{
+			name: "broad deny of another caller does not stop mint",
+			policy: &Policy{Rules: []Rule{
+				{Name: "compromised", Effect: Deny, Callers: []string{compromised}},
+				grantMint,
+			}},
+			req:   Request{Caller: platform, Verb: VerbMint, SPIFFEID: legacyID},
+			allow: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := &Engine{Logger: quietLogger(), patterns: map[string]*regexp.Regexp{}}
+			if tt.policy != nil {
+				pe = newEngine(t, tt.policy)
+			}
+			if d := pe.decide(tt.req); d.Allowed != tt.allow {
+				t.Errorf("allowed = %v, want %v (%s)", d.Allowed, tt.allow, d.Reason)
+			}
+		})
+	}
+}
+
+func TestExplicitFlags(t *testing.T) {
+	const platform = "spiffe://example.org/platform/ci"
+	tests := []struct {
+		name   string
+		policy *Policy
+		flags  []string
+		allow  bool
+	}{
+		{name: "no policy denies admin", flags: []string{FlagAdmin}, allow: false},
+		{name: "no policy denies downstream", flags: []string{FlagDownstream}, allow: false},
+		{name: "no policy allows kubeconfig", flags: []string{FlagKubeConfig}, allow: true},
+		{
+			name:   "allow rule without flags does not grant admin",
+			policy: &Policy{Rules: []Rule{{Name: "platform", Effect: Allow, Callers: []string{"spiffe://example.org/platform/*"}}}},
+			flags:  []string{FlagAdmin},
+			allow:  false,
+		},
+		{
+			name:   "wildcard flag does not grant downstream",
+			policy: &Policy{Rules: []Rule{{Name: "platform", Effect: Allow, Flags: []string{"*"}}}},
+			flags:  []string{FlagDownstream},
+			allow:  false,
+		},
+		{
+			name:   "default allow does not grant admin",
+			policy: &Policy{DefaultEffect: Allow},
+			flags:  []string{FlagAdmin},
+			allow:  false,
+		},
+		{
+			name:   "rule listing the flag grants it",
+			policy: &Policy{Rules: []Rule{{Name: "downstream", Effect: Allow, Flags: []string{FlagDownstream}}}},
+			flags:  []string{FlagDownstream},
+			allow:  true,
+		},
+		{
+			name:   "every explicit flag must be listed",
+			policy: &Policy{Rules: []Rule{{Name: "downstream", Effect: Allow, Flags: []string{FlagDownstream}}}},
+			flags:  []string{FlagDownstream, FlagAdmin},
+			allow:  false,
+		},
+		{
+			name: "deny rule without flags stops admin",
+			policy: &Policy{Rules: []Rule{
+				{Name: "deny-ci", Effect: Deny, Callers: []string{platform}},
+				{Name: "admin", Effect: Allow, Flags: []string{FlagAdmin}},
+			}},
+			flags: []string{FlagAdmin},
+			allow: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pe := &Engine{Logger: quietLogger(), patterns: map[string]*regexp.Regexp{}}
+			if tt.policy != nil {
+				pe = newEngine(t, tt.policy)
+			}
+			d := pe.decide(Request{Caller: platform, Verb: VerbCreate, Flags: tt.flags})
+			if d.Allowed != tt.allow {
+				t.Errorf("allowed = %v, want %v (%s)", d.Allowed, tt.allow, d.Reason)
+			}
+		})
+	}
+}
+
+func TestCallerRules(t *testing.T) {
+	pe := newEngine(t, &Policy{Rules: []Rule{
+		{Name: "everyone", Effect: Allow, Verbs: []string{VerbRead}},
+		{Name: "wildcard", Effect: Allow, Callers: []string{"*"}},
+		{Name: "anonymous", Effect: Deny, Callers: []string{AnonymousCaller}},
+		{Name: "platform", Effect: Allow, Callers: []string{"spiffe://example.org/platform/*"}},
+		{Name: "mixed", Effect: Allow, Callers: []string{AnonymousCaller, "spiffe://example.org/ci"}},
+	}})
+	got := pe.CallerRules()
+	want := []string{"platform", "mixed"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("CallerRules() = %v, want %v", got, want)
+	}
+}
+
+func TestRuleMatching(t *testing.T) {
+	pe := newEngine(t, &Policy{DefaultEffect: Allow, Rules: []Rule{
+		{Name: "deny-kube-system", Effect: Deny, Namespaces: []string{"kube-system"}},
+		{Name: "ci-prod", Effect: Allow, Callers: []string{"spiffe://example.org/ci"}, Clusters: []string{"prod-*"}},
+		{Name: "deny-prod", Effect: Deny, Clusters: []string{"prod-*"}},
+		{Name: "deny-evict", Effect: Deny, Flags: []string{FlagEvict}},
+		{Name: "deny-partner", Effect: Deny, Backends: []string{"partner"}},
+	}})
+	tests := []struct {
+		name  string
+		req   Request
+		allow bool
+		rule  string
+	}{
+		{name: "first match wins", req: Request{Caller: "spiffe://example.org/ci", Namespace: "kube-system", Cluster: "prod-a"}, allow: false, rule: "deny-kube-system"},
+		{name: "every field must match", req: Request{Caller: "spiffe://example.org/ci", Cluster: "prod-a"}, allow: true, rule: "ci-prod"},
+		{name: "later rule after a partial match", req: Request{Caller: "spiffe://example.org/dev", Cluster: "prod-a"}, allow: false, rule: "deny-prod"},
+		{name: "patterns are anchored", req: Request{Caller: "spiffe://example.org/ci/extra", Cluster: "prod-a"}, allow: false, rule: "deny-prod"},
+		{name: "dots are literal", req: Request{Caller: "spiffe://exampleXorg/ci", Cluster: "prod-a"}, allow: false, rule: "deny-prod"},
+		{name: "wildcard needs its prefix", req: Request{Cluster: "staging-prod-a"}, allow: true},
+		{name: "any listed flag matches", req: Request{Flags: []string{FlagKubeConfig, FlagEvict}}, allow: false, rule: "deny-evict"},
+		{name: "rule with flags needs a flag", req: Request{Backend: "partner"}, allow: false, rule: "deny-partner"},
+		{name: "default effect", req: Request{Cluster: "dev"}, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := pe.decide(tt.req)
+			if d.Allowed != tt.allow || d.Rule != tt.rule {
+				t.Errorf("decision = %v by %q, want %v by %q", d.Allowed, d.Rule, tt.allow, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNewRejectsInvalidEffects(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *Policy
+	}{
+		{name: "default effect", policy: &Policy{DefaultEffect: "permit"}},
+		{name: "rule effect", policy: &Policy{Rules: []Rule{{Name: "r", Effect: "Allow"}}}},
+		{name: "missing rule effect", policy: &Policy{Rules: []Rule{{Name: "r"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.policy, quietLogger()); err == nil {
+				t.Error("New() accepted the policy")
+			}
+		})
+	}
+}
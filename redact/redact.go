@@ -0,0 +1,72 @@
+package redact
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+const Mask = "[REDACTED]"
+
+var (
+	// kubeconfig and generic credential keys, in YAML or key=value form
+	credentialKeys = regexp.MustCompile(`(?i)((?:client-key-data|client-certificate-data|password|id-token|refresh-token|access-token|kubeConfig)"?\s*[:=]\s*"?)[^\s",}]+`)
+	// "token" is a word of ordinary messages, e.g. "failed to create join token: rpc error", so
+	// only its key forms are masked: a (escaped) JSON key, a YAML key starting a line, or token=
+	tokenKey   = regexp.MustCompile(`(?im)(\\?"token\\?"\s*:\s*\\?"?|^[\t -]*token:\s*"?|\btoken=\s*"?)[^\s"\\,}]+`)
+	bearer     = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	jwt        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	privateKey = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+	// long base64 blobs are encoded kubeconfigs, keys or certs
+	base64Blob = regexp.MustCompile(`[A-Za-z0-9+/]{200,}={0,2}`)
+	// agents attested with a join token are named spiffe://<td>/spire/agent/join_token/<token>
+	joinTokenPath = regexp.MustCompile(`(/join_token/)[^/\s"',}]+`)
+)
+
+// String removes credential material from s.
+func String(s string) string {
+	s = privateKey.ReplaceAllString(s, Mask)
+	s = credentialKeys.ReplaceAllString(s, "${1}"+Mask)
+	s = tokenKey.ReplaceAllString(s, "${1}"+Mask)
+	s = bearer.ReplaceAllString(s, "${1}"+Mask)
+	s = jwt.ReplaceAllString(s, Mask)
+	s = base64Blob.ReplaceAllString(s, Mask)
+	s = SPIFFEID(s)
+	return s
+}
+
+// SPIFFEID masks the join token in the SPIFFE IDs of join token agents
+func SPIFFEID(s string) string {
+	return joinTokenPath.ReplaceAllString(s, "${1}"+Mask)
+}
+
+// Hook scrubs the message and fields of every log entry before it is formatted.
+type Hook struct{}
+
+func (Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = String(entry.Message)
+	for k, v := range entry.Data {
+		switch val := v.(type) {
+		case string:
+			entry.Data[k] = String(val)
+		case error:
+			entry.Data[k] = String(val.Error())
+		case fmt.Stringer:
+			entry.Data[k] = String(val.String())
+		}
+	}
+	return nil
+}
+
+// NewLogger returns a logrus logger with the redaction hook installed.
+// Use it instead of logrus.New so credentials never reach the log output.
+func NewLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.AddHook(Hook{})
+	return logger
+}
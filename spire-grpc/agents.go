@@ -0,0 +1,174 @@
+package spire_grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const agentPageSize = 500
+
+// AgentFilter selects agents. Selectors are "type:value" pairs, SelectorMatch is one of
+// exact, subset, superset or any (default superset). ExpiresBefore is an RFC3339 time.
+type AgentFilter struct {
+	AttestationType string
+	Selectors       []string
+	SelectorMatch   string
+	Banned          *bool
+	CanReattest     *bool
+	ExpiresBefore   string
+}
+
+func (f AgentFilter) selectorMatch() (*types.SelectorMatch, error) {
+	if len(f.Selectors) == 0 {
+		return nil, nil
+	}
+	match := types.SelectorMatch_MATCH_SUPERSET
+	if f.SelectorMatch != "" {
+		v, ok := types.SelectorMatch_MatchBehavior_value["MATCH_"+strings.ToUpper(f.SelectorMatch)]
+		if !ok {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "match", Message: "must be one of exact, subset, superset, any"}}}
+		}
+		match = types.SelectorMatch_MatchBehavior(v)
+	}
+	sm := &types.SelectorMatch{Match: match}
+	for _, s := range f.Selectors {
+		t, v, ok := strings.Cut(s, ":")
+		if !ok || t == "" || v == "" {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "selector", Message: fmt.Sprintf("%q must be type:value", s)}}}
+		}
+		sm.Selectors = append(sm.Selectors, &types.Selector{Type: t, Value: v})
+	}
+	return sm, nil
+}
+
+func boolValue(b *bool) *wrapperspb.BoolValue {
+	if b == nil {
+		return nil
+	}
+	return wrapperspb.Bool(*b)
+}
+
+// ParseSPIFFEID converts a SPIFFE ID string to the API type
+func ParseSPIFFEID(id string) (*types.SPIFFEID, error) {
+	sid, err := spiffeid.FromString(id)
+	if err != nil {
+		return nil, err
+	}
+	return &types.SPIFFEID{TrustDomain: sid.TrustDomain().Name(), Path: sid.Path()}, nil
+}
+
+// SPIFFEIDString formats the API type as a SPIFFE ID string
+func SPIFFEIDString(id *types.SPIFFEID) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprintf("spiffe://%s%s", id.TrustDomain, id.Path)
+}
+
+func (sc *SPIREClient) ListAgents(ctx context.Context, f AgentFilter) ([]*types.Agent, error) {
+	sm, err := f.selectorMatch()
+	if err != nil {
+		return nil, err
+	}
+	req := &agentpb.ListAgentsRequest{
+		Filter: &agentpb.ListAgentsRequest_Filter{
+			ByAttestationType: f.AttestationType,
+			BySelectorMatch:   sm,
+			ByBanned:          boolValue(f.Banned),
+			ByCanReattest:     boolValue(f.CanReattest),
+			ByExpiresBefore:   f.ExpiresBefore,
+		},
+		PageSize: agentPageSize,
+	}
+	var agents []*types.Agent
+	for {
+		resp, err := sc.Agents.ListAgents(ctx, req)
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to list agents: %v", err)
+			return nil, err
+		}
+		agents = append(agents, resp.Agents...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	sc.log(ctx).Debugf("Listed %d agents", len(agents))
+	return agents, nil
+}
+
+func (sc *SPIREClient) CountAgents(ctx context.Context, f AgentFilter) (int32, error) {
+	sm, err := f.selectorMatch()
+	if err != nil {
+		return 0, err
+	}
+	resp, err := sc.Agents.CountAgents(ctx, &agentpb.CountAgentsRequest{
+		Filter: &agentpb.CountAgentsRequest_Filter{
+			ByAttestationType: f.AttestationType,
+			BySelectorMatch:   sm,
+			ByBanned:          boolValue(f.Banned),
+			ByCanReattest:     boolValue(f.CanReattest),
+			ByExpiresBefore:   f.ExpiresBefore,
+		},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to count agents: %v", err)
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+func (sc *SPIREClient) GetAgent(ctx context.Context, id *types.SPIFFEID) (*types.Agent, error) {
+	agent, err := sc.Agents.GetAgent(ctx, &agentpb.GetAgentRequest{Id: id})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to get agent %s: %v", SPIFFEIDString(id), err)
+		return nil, err
+	}
+	return agent, nil
+}
+
+// BanAgent deletes the agent's attested node and prevents it from attesting again
+func (sc *SPIREClient) BanAgent(ctx context.Context, id *types.SPIFFEID) error {
+	sc.log(ctx).Infof("Banning agent %s", SPIFFEIDString(id))
+	if _, err := sc.Agents.BanAgent(ctx, &agentpb.BanAgentRequest{Id: id}); err != nil {
+		sc.log(ctx).Errorf("Failed to ban agent %s: %v", SPIFFEIDString(id), err)
+		return err
+	}
+	return nil
+}
+
+// EvictAgent deletes the agent's attested node, the agent can attest again
+func (sc *SPIREClient) EvictAgent(ctx context.Context, id *types.SPIFFEID) error {
+	sc.log(ctx).Infof("Evicting agent %s", SPIFFEIDString(id))
+	if _, err := sc.Agents.DeleteAgent(ctx, &agentpb.DeleteAgentRequest{Id: id}); err != nil {
+		sc.log(ctx).Errorf("Failed to evict agent %s: %v", SPIFFEIDString(id), err)
+		return err
+	}
+	return nil
+}
+
+// EvictClusterAgents evicts every agent attested with k8s_psat for the cluster and returns their IDs
+func (sc *SPIREClient) EvictClusterAgents(ctx context.Context, cluster string) ([]string, error) {
+	agents, err := sc.ListAgents(ctx, AgentFilter{
+		AttestationType: SpirePsat,
+		Selectors:       []string{fmt.Sprintf("%s:%s:%s", SpirePsat, ClusterSelectorPsat, cluster)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var evicted []string
+	for _, agent := range agents {
+		if err := sc.EvictAgent(ctx, agent.Id); err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, SPIFFEIDString(agent.Id))
+	}
+	sc.log(ctx).Infof("Evicted %d agents of cluster %s", len(evicted), cluster)
+	return evicted, nil
+}
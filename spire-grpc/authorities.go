@@ -0,0 +1,167 @@
+package spire_grpc
+
+import (
+	"context"
+
+	localauthoritypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/localauthority/v1"
+)
+
+// Local authority types
+const (
+	AuthorityX509 = "x509"
+	AuthorityJWT  = "jwt"
+)
+
+// Authority is a local X.509 or JWT authority. The ID is the subject key ID of the CA
+// certificate for X.509 and the key ID for JWT.
+type Authority struct {
+	AuthorityID                   string `json:"authorityId"`
+	ExpiresAt                     int64  `json:"expiresAt"`
+	UpstreamAuthoritySubjectKeyID string `json:"upstreamAuthoritySubjectKeyId,omitempty"`
+}
+
+// AuthorityStates are the authorities in each stage of a rotation. Prepared authorities are in
+// the bundle but not used for signing, old authorities were used for signing before the active one.
+type AuthorityStates struct {
+	Active   *Authority `json:"active,omitempty"`
+	Prepared *Authority `json:"prepared,omitempty"`
+	Old      *Authority `json:"old,omitempty"`
+}
+
+func toAuthority(s *localauthoritypb.AuthorityState) *Authority {
+	if s == nil {
+		return nil
+	}
+	return &Authority{
+		AuthorityID:                   s.AuthorityId,
+		ExpiresAt:                     s.ExpiresAt,
+		UpstreamAuthoritySubjectKeyID: s.UpstreamAuthoritySubjectKeyId,
+	}
+}
+
+func invalidAuthorityType() error {
+	return &ValidationError{Fields: []FieldError{{Field: "type", Message: "must be one of x509, jwt"}}}
+}
+
+func (sc *SPIREClient) GetAuthorityStates(ctx context.Context, kind string) (*AuthorityStates, error) {
+	var active, prepared, old *localauthoritypb.AuthorityState
+	switch kind {
+	case AuthorityX509:
+		resp, err := sc.LocalAuthority.GetX509AuthorityState(ctx, &localauthoritypb.GetX509AuthorityStateRequest{})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to get X.509 authority state: %v", err)
+			return nil, err
+		}
+		active, prepared, old = resp.Active, resp.Prepared, resp.Old
+	case AuthorityJWT:
+		resp, err := sc.LocalAuthority.GetJWTAuthorityState(ctx, &localauthoritypb.GetJWTAuthorityStateRequest{})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to get JWT authority state: %v", err)
+			return nil, err
+		}
+		active, prepared, old = resp.Active, resp.Prepared, resp.Old
+	default:
+		return nil, invalidAuthorityType()
+	}
+	return &AuthorityStates{Active: toAuthority(active), Prepared: toAuthority(prepared), Old: toAuthority(old)}, nil
+}
+
+// PrepareAuthority adds a new authority to the bundle without signing with it
+func (sc *SPIREClient) PrepareAuthority(ctx context.Context, kind string) (*Authority, error) {
+	var state *localauthoritypb.AuthorityState
+	switch kind {
+	case AuthorityX509:
+		resp, err := sc.LocalAuthority.PrepareX509Authority(ctx, &localauthoritypb.PrepareX509AuthorityRequest{})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to prepare X.509 authority: %v", err)
+			return nil, err
+		}
+		state = resp.PreparedAuthority
+	case AuthorityJWT:
+		resp, err := sc.LocalAuthority.PrepareJWTAuthority(ctx, &localauthoritypb.PrepareJWTAuthorityRequest{})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to prepare JWT authority: %v", err)
+			return nil, err
+		}
+		state = resp.PreparedAuthority
+	default:
+		return nil, invalidAuthorityType()
+	}
+	sc.log(ctx).Infof("Prepared %s authority %s", kind, state.GetAuthorityId())
+	return toAuthority(state), nil
+}
+
+// ActivateAuthority starts signing with the prepared authority id
+func (sc *SPIREClient) ActivateAuthority(ctx context.Context, kind string, id string) (*Authority, error) {
+	var state *localauthoritypb.AuthorityState
+	switch kind {
+	case AuthorityX509:
+		resp, err := sc.LocalAuthority.ActivateX509Authority(ctx, &localauthoritypb.ActivateX509AuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to activate X.509 authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.ActivatedAuthority
+	case AuthorityJWT:
+		resp, err := sc.LocalAuthority.ActivateJWTAuthority(ctx, &localauthoritypb.ActivateJWTAuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to activate JWT authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.ActivatedAuthority
+	default:
+		return nil, invalidAuthorityType()
+	}
+	sc.log(ctx).Infof("Activated %s authority %s", kind, id)
+	return toAuthority(state), nil
+}
+
+// TaintAuthority marks the old authority id as tainted, agents rotate SVIDs signed by it
+func (sc *SPIREClient) TaintAuthority(ctx context.Context, kind string, id string) (*Authority, error) {
+	var state *localauthoritypb.AuthorityState
+	switch kind {
+	case AuthorityX509:
+		resp, err := sc.LocalAuthority.TaintX509Authority(ctx, &localauthoritypb.TaintX509AuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to taint X.509 authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.TaintedAuthority
+	case AuthorityJWT:
+		resp, err := sc.LocalAuthority.TaintJWTAuthority(ctx, &localauthoritypb.TaintJWTAuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to taint JWT authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.TaintedAuthority
+	default:
+		return nil, invalidAuthorityType()
+	}
+	sc.log(ctx).Warnf("Tainted %s authority %s", kind, id)
+	return toAuthority(state), nil
+}
+
+// RevokeAuthority removes the tainted authority id from the bundle
+func (sc *SPIREClient) RevokeAuthority(ctx context.Context, kind string, id string) (*Authority, error) {
+	var state *localauthoritypb.AuthorityState
+	switch kind {
+	case AuthorityX509:
+		resp, err := sc.LocalAuthority.RevokeX509Authority(ctx, &localauthoritypb.RevokeX509AuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to revoke X.509 authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.RevokedAuthority
+	case AuthorityJWT:
+		resp, err := sc.LocalAuthority.RevokeJWTAuthority(ctx, &localauthoritypb.RevokeJWTAuthorityRequest{AuthorityId: id})
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to revoke JWT authority %s: %v", id, err)
+			return nil, err
+		}
+		state = resp.RevokedAuthority
+	default:
+		return nil, invalidAuthorityType()
+	}
+	sc.log(ctx).Warnf("Revoked %s authority %s", kind, id)
+	return toAuthority(state), nil
+}
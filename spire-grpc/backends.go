@@ -0,0 +1,193 @@
+package spire_grpc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Reload strategies, how the SPIRE server is told to re-read the k8s_psat config
+const (
+	// ReloadSignal sends SIGUSR1 to every local process named ProcessName (default spire-server)
+	ReloadSignal = "signal"
+	// ReloadPIDFile sends SIGUSR1 to the process in PIDFile, for several servers on one host
+	ReloadPIDFile = "pidfile"
+	// ReloadExec runs Command, e.g. ssh or kubectl exec to signal a replica on another host
+	ReloadExec = "exec"
+	// ReloadNone leaves the reload to the operator, e.g. for a config volume watched elsewhere
+	ReloadNone = "none"
+)
+
+const defaultServerProcess = "spire-server"
+
+type ReloadConfig struct {
+	Strategy    string   `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	ProcessName string   `json:"processName,omitempty" yaml:"processName,omitempty"`
+	PIDFile     string   `json:"pidFile,omitempty" yaml:"pidFile,omitempty"`
+	Command     []string `json:"command,omitempty" yaml:"command,omitempty"`
+}
+
+// BackendConfig is one SPIRE server managed by spire-api. Mode is how it is reached: workload
+// (default), admin or static. UDSPath is the Workload API socket used for the mTLS connection,
+// it defaults to the global -uds-path. AdminSocket is the server's socket_path for mode admin,
+// Certs the certificate files for mode static. Timeouts bound the RPCs to the server.
+// For an HA deployment Servers lists every replica instead of Server, and Replicas says where
+// each replica reads its config and how it is reloaded.
+type BackendConfig struct {
+	Name        string          `json:"name" yaml:"name"`
+	Server      string          `json:"server,omitempty" yaml:"server,omitempty"`
+	Servers     []string        `json:"servers,omitempty" yaml:"servers,omitempty"`
+	Replicas    []ReplicaConfig `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	Mode        string          `json:"mode,omitempty" yaml:"mode,omitempty"`
+	AdminSocket string          `json:"adminSocket,omitempty" yaml:"adminSocket,omitempty"`
+	Certs       CertFiles       `json:"certs,omitempty" yaml:"certs,omitempty"`
+	Timeouts    Timeouts        `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	TrustDomain string          `json:"trustDomain" yaml:"trustDomain"`
+	SpireDir    string          `json:"spireDir" yaml:"spireDir"`
+	UDSPath     string          `json:"udsPath,omitempty" yaml:"udsPath,omitempty"`
+	Reload      ReloadConfig    `json:"reload,omitempty" yaml:"reload,omitempty"`
+	// Default serves the unprefixed /v1 routes, the first backend is the default if none is set
+	Default bool `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+type BackendsFile struct {
+	Backends []BackendConfig `json:"backends" yaml:"backends"`
+}
+
+// LoadBackends reads a YAML or JSON file of backends and validates them
+func LoadBackends(path string) ([]BackendConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &BackendsFile{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	backends, err := ValidateBackends(f.Backends)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return backends, nil
+}
+
+// ValidateBackends checks every backend and that names and trust domains are unique. The first
+// backend becomes the default if none is marked.
+func ValidateBackends(backends []BackendConfig) ([]BackendConfig, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends")
+	}
+	names := map[string]bool{}
+	trustDomains := map[string]bool{}
+	defaults := 0
+	for i, b := range backends {
+		if err := ValidateDNS1123Label(b.Name); err != nil {
+			return nil, fmt.Errorf("backend %d: name %q %v", i, b.Name, err)
+		}
+		if (len(b.Addresses()) == 0 && b.Mode != ModeAdminSocket) || b.TrustDomain == "" || b.SpireDir == "" {
+			return nil, fmt.Errorf("backend %s: server or servers, trustDomain and spireDir are required", b.Name)
+		}
+		if _, err := b.Connector(ConnectorDefaults{}); err != nil {
+			return nil, fmt.Errorf("backend %s: %v", b.Name, err)
+		}
+		for _, r := range b.ReplicaConfigs() {
+			if r.Address == "" {
+				return nil, fmt.Errorf("backend %s: replica address is required", b.Name)
+			}
+			if err := r.Reload.validate(); err != nil {
+				return nil, fmt.Errorf("backend %s: replica %s: %v", b.Name, r.Address, err)
+			}
+			strategy := r.Reload.Strategy
+			if strategy == "" {
+				strategy = b.Reload.Strategy
+			}
+			// signals only reach local processes, a remote replica would silently keep its old config
+			if !isLocalAddress(r.Address) && strategy != ReloadExec && strategy != ReloadNone {
+				if len(b.Replicas) == 0 {
+					return nil, fmt.Errorf("backend %s: server %s is not local, set reload to exec or none, or list the servers as replicas with their own reload", b.Name, r.Address)
+				}
+				return nil, fmt.Errorf("backend %s: replica %s is not local, its reload strategy must be exec or none", b.Name, r.Address)
+			}
+		}
+		if names[b.Name] {
+			return nil, fmt.Errorf("backend %s is defined twice", b.Name)
+		}
+		if trustDomains[b.TrustDomain] {
+			return nil, fmt.Errorf("backend %s: trust domain %s is used by another backend", b.Name, b.TrustDomain)
+		}
+		if err := b.Reload.validate(); err != nil {
+			return nil, fmt.Errorf("backend %s: %v", b.Name, err)
+		}
+		names[b.Name], trustDomains[b.TrustDomain] = true, true
+		if b.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return nil, fmt.Errorf("%d default backends", defaults)
+	}
+	if defaults == 0 {
+		backends[0].Default = true
+	}
+	return backends, nil
+}
+
+// ReplicaConfigs returns Replicas. Without replicas, several Servers are each a replica that
+// shares the backend's SPIRE dir and reload.
+func (b BackendConfig) ReplicaConfigs() []ReplicaConfig {
+	if len(b.Replicas) > 0 || len(b.Servers) < 2 {
+		return b.Replicas
+	}
+	var replicas []ReplicaConfig
+	for _, s := range b.Servers {
+		replicas = append(replicas, ReplicaConfig{Address: s})
+	}
+	return replicas
+}
+
+// Addresses returns Servers, or Server for a single SPIRE server
+func (b BackendConfig) Addresses() []string {
+	if len(b.Servers) > 0 {
+		return b.Servers
+	}
+	if b.Server != "" {
+		return []string{b.Server}
+	}
+	return nil
+}
+
+// isLocalAddress reports whether addr is a unix socket or a loopback host:port
+func isLocalAddress(addr string) bool {
+	if strings.HasPrefix(addr, "unix:") {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (rc ReloadConfig) validate() error {
+	switch rc.Strategy {
+	case "", ReloadSignal, ReloadNone:
+	case ReloadPIDFile:
+		if rc.PIDFile == "" {
+			return fmt.Errorf("reload strategy pidfile needs pidFile")
+		}
+	case ReloadExec:
+		if len(rc.Command) == 0 {
+			return fmt.Errorf("reload strategy exec needs command")
+		}
+	default:
+		return fmt.Errorf("invalid reload strategy %q", rc.Strategy)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestValidateBackends(t *testing.T) {
+	backend := func(name, trustDomain string, replicas ...ReplicaConfig) BackendConfig {
+		return BackendConfig{Name: name, Server: "spire-server.example.org:8081", TrustDomain: trustDomain, SpireDir: "/opt/spire", Replicas: replicas}
+	}
+	exec := ReloadConfig{Strategy: ReloadExec, Command: []string{"ssh", "spire-server-2.example.org", "pkill", "-USR1", "spire-server"}}
+	tests := []struct {
+		name     string
+		backends []BackendConfig
+		err      string
+	}{
+		{name: "no backends", err: "no backends"},
+		{name: "single backend", backends: []BackendConfig{backend("prod", "example.org")}},
+		{name: "invalid name", backends: []BackendConfig{backend("Prod", "example.org")}, err: `name "Prod"`},
+		{name: "missing spireDir", backends: []BackendConfig{{Name: "prod", Server: "spire-server.example.org:8081", TrustDomain: "example.org"}}, err: "spireDir are required"},
+		{
+			name:     "duplicate name",
+			backends: []BackendConfig{backend("prod", "example.org"), backend("prod", "staging.example.org")},
+			err:      "defined twice",
+		},
+		{
+			name:     "duplicate trust domain",
+			backends: []BackendConfig{backend("prod", "example.org"), backend("staging", "example.org")},
+			err:      "used by another backend",
+		},
+		{
+			name:     "invalid reload strategy",
+			backends: []BackendConfig{{Name: "prod", Server: "spire-server.example.org:8081", TrustDomain: "example.org", SpireDir: "/opt/spire", Reload: ReloadConfig{Strategy: "restart"}}},
+			err:      `invalid reload strategy "restart"`,
+		},
+		{
+			name:     "remote replica with exec reload",
+			backends: []BackendConfig{backend("prod", "example.org", ReplicaConfig{Address: "spire-server-2.example.org:8081", Reload: exec})},
+		},
+		{
+			name:     "remote replica with no reload",
+			backends: []BackendConfig{backend("prod", "example.org", ReplicaConfig{Address: "spire-server-2.example.org:8081", Reload: ReloadConfig{Strategy: ReloadNone}})},
+		},
+		{
+			name:     "remote replica inheriting the signal reload",
+			backends: []BackendConfig{backend("prod", "example.org", ReplicaConfig{Address: "spire-server-2.example.org:8081"})},
+			err:      "must be exec or none",
+		},
+		{
+			name:     "remote replica with pidfile reload",
+			backends: []BackendConfig{backend("prod", "example.org", ReplicaConfig{Address: "10.0.0.2:8081", Reload: ReloadConfig{Strategy: ReloadPIDFile, PIDFile: "/run/spire-server.pid"}})},
+			err:      "must be exec or none",
+		},
+		{
+			name:     "remote replica inheriting an exec reload",
+			backends: []BackendConfig{{Name: "prod", Server: "spire-server.example.org:8081", TrustDomain: "example.org", SpireDir: "/opt/spire", Reload: exec, Replicas: []ReplicaConfig{{Address: "spire-server-2.example.org:8081"}}}},
+		},
+		{
+			name:     "remote servers with the signal reload",
+			backends: []BackendConfig{{Name: "prod", Servers: []string{"127.0.0.1:8081", "spire-server-2.example.org:8081"}, TrustDomain: "example.org", SpireDir: "/opt/spire"}},
+			err:      "server spire-server-2.example.org:8081 is not local",
+		},
+		{
+			name:     "remote servers with an exec reload",
+			backends: []BackendConfig{{Name: "prod", Servers: []string{"spire-server-1.example.org:8081", "spire-server-2.example.org:8081"}, TrustDomain: "example.org", SpireDir: "/opt/spire", Reload: exec}},
+		},
+		{
+			name:     "local servers with the signal reload",
+			backends: []BackendConfig{{Name: "prod", Servers: []string{"127.0.0.1:8081", "localhost:9081"}, TrustDomain: "example.org", SpireDir: "/opt/spire"}},
+		},
+		{
+			name:     "local replica with signal reload",
+			backends: []BackendConfig{backend("prod", "example.org", ReplicaConfig{Address: "127.0.0.1:8081"}, ReplicaConfig{Address: "localhost:9081", Reload: ReloadConfig{Strategy: ReloadPIDFile, PIDFile: "/run/spire-server-2.pid"}})},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateBackends(tt.backends)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error = %v, want it to contain %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateBackendsDefault(t *testing.T) {
+	backends, err := ValidateBackends([]BackendConfig{
+		{Name: "prod", Server: "spire-server.example.org:8081", TrustDomain: "example.org", SpireDir: "/opt/spire"},
+		{Name: "staging", Server: "spire-server.staging.example.org:8081", TrustDomain: "staging.example.org", SpireDir: "/opt/spire-staging"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backends[0].Default || backends[1].Default {
+		t.Errorf("default = %v, %v, want the first backend", backends[0].Default, backends[1].Default)
+	}
+}
+
+func TestReloadSignalNoProcess(t *testing.T) {
+	var out bytes.Buffer
+	sc := &SPIREClient{Logger: testLogger(&out)}
+	reloaded, err := sc.reload(context.Background(), ReplicaConfig{Address: "127.0.0.1:8081", Reload: ReloadConfig{Strategy: ReloadSignal, ProcessName: "spire-server-not-running"}})
+	if !reloaded || err == nil {
+		t.Errorf("reload = %v, %v, want an error when no process is signalled", reloaded, err)
+	}
+}
@@ -0,0 +1,110 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	bundlepb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/bundle/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+// Bundle formats served by the API
+const (
+	BundleFormatPEM    = "pem"
+	BundleFormatJWKS   = "jwks"
+	BundleFormatSPIFFE = "spiffe"
+)
+
+// GetBundle returns the trust bundle of the server's own trust domain
+func (sc *SPIREClient) GetBundle(ctx context.Context) (*types.Bundle, error) {
+	b, err := sc.Bundles.GetBundle(ctx, &bundlepb.GetBundleRequest{})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to get bundle: %v", err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// MarshalBundle encodes a bundle as PEM X.509 authorities, a JWKS of the JWT authorities,
+// or a SPIFFE bundle holding both.
+func MarshalBundle(b *types.Bundle, format string) ([]byte, error) {
+	sb, err := ToSPIFFEBundle(b)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case BundleFormatPEM:
+		var buf bytes.Buffer
+		for _, cert := range sb.X509Authorities() {
+			if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return nil, err
+			}
+		}
+		return buf.Bytes(), nil
+	case BundleFormatJWKS:
+		return jwtbundle.FromJWTAuthorities(sb.TrustDomain(), sb.JWTAuthorities()).Marshal()
+	case BundleFormatSPIFFE:
+		return sb.Marshal()
+	default:
+		return nil, &ValidationError{Fields: []FieldError{{Field: "format", Message: "must be one of pem, jwks, spiffe"}}}
+	}
+}
+
+// ToSPIFFEBundle converts an API bundle to a go-spiffe bundle
+func ToSPIFFEBundle(b *types.Bundle) (*spiffebundle.Bundle, error) {
+	td, err := spiffeid.TrustDomainFromString(b.TrustDomain)
+	if err != nil {
+		return nil, err
+	}
+	sb := spiffebundle.New(td)
+	for _, a := range b.X509Authorities {
+		cert, err := x509.ParseCertificate(a.Asn1)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse X.509 authority: %v", err)
+		}
+		sb.AddX509Authority(cert)
+	}
+	for _, a := range b.JwtAuthorities {
+		key, err := x509.ParsePKIXPublicKey(a.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse JWT authority %s: %v", a.KeyId, err)
+		}
+		if err := sb.AddJWTAuthority(a.KeyId, key); err != nil {
+			return nil, err
+		}
+	}
+	if b.RefreshHint > 0 {
+		sb.SetRefreshHint(time.Duration(b.RefreshHint) * time.Second)
+	}
+	sb.SetSequenceNumber(b.SequenceNumber)
+	return sb, nil
+}
+
+// FromSPIFFEBundle converts a go-spiffe bundle to an API bundle
+func FromSPIFFEBundle(sb *spiffebundle.Bundle) (*types.Bundle, error) {
+	b := &types.Bundle{TrustDomain: sb.TrustDomain().Name()}
+	for _, cert := range sb.X509Authorities() {
+		b.X509Authorities = append(b.X509Authorities, &types.X509Certificate{Asn1: cert.Raw})
+	}
+	for keyID, key := range sb.JWTAuthorities() {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal JWT authority %s: %v", keyID, err)
+		}
+		b.JwtAuthorities = append(b.JwtAuthorities, &types.JWTKey{PublicKey: der, KeyId: keyID})
+	}
+	if hint, ok := sb.RefreshHint(); ok {
+		b.RefreshHint = int64(hint / time.Second)
+	}
+	if seq, ok := sb.SequenceNumber(); ok {
+		b.SequenceNumber = seq
+	}
+	return b, nil
+}
@@ -0,0 +1,134 @@
+package spire_grpc
+
+import (
+	"context"
+	"path"
+	"spire-api/logging"
+	"spire-api/metrics"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = 30 * time.Second
+
+	// readAttempts is how often a read is tried while the SPIRE server is Unavailable
+	readAttempts   = 3
+	readRetryDelay = 200 * time.Millisecond
+)
+
+// Timeouts bound every RPC to the SPIRE server. Read is used for List, Get and Count RPCs and
+// health checks, Write for every other RPC. Operations overrides single RPCs by method name,
+// e.g. BatchCreateEntry or MintX509SVID. Zero values use the defaults.
+type Timeouts struct {
+	Read       time.Duration            `json:"read,omitempty" yaml:"read,omitempty"`
+	Write      time.Duration            `json:"write,omitempty" yaml:"write,omitempty"`
+	Operations map[string]time.Duration `json:"operations,omitempty" yaml:"operations,omitempty"`
+}
+
+// merge returns t with its zero fields taken from defaults, operations of t win
+func (t Timeouts) merge(defaults Timeouts) Timeouts {
+	if t.Read == 0 {
+		t.Read = defaults.Read
+	}
+	if t.Write == 0 {
+		t.Write = defaults.Write
+	}
+	ops := map[string]time.Duration{}
+	for op, d := range defaults.Operations {
+		ops[op] = d
+	}
+	for op, d := range t.Operations {
+		ops[op] = d
+	}
+	t.Operations = ops
+	return t
+}
+
+// isRead reports whether the RPC only reads and can be retried
+func isRead(method string) bool {
+	for _, prefix := range []string{"List", "Get", "Count", "Check"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t Timeouts) timeout(method string, read bool) time.Duration {
+	if d, ok := t.Operations[method]; ok && d > 0 {
+		return d
+	}
+	if read {
+		if t.Read > 0 {
+			return t.Read
+		}
+		return DefaultReadTimeout
+	}
+	if t.Write > 0 {
+		return t.Write
+	}
+	return DefaultWriteTimeout
+}
+
+// callInterceptor applies the timeouts to every unary RPC. Reads follow the caller's context,
+// so a client disconnect cancels them, and are retried with backoff while the server is
+// Unavailable. Writes are not cancelled with the caller's context: a mutation that reached the
+// SPIRE server completes and is recorded, bounded by the write timeout.
+func callInterceptor(t Timeouts, logger *logrus.Logger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		method := path.Base(fullMethod)
+		read := isRead(method)
+		timeout := t.timeout(method, read)
+		if !read {
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+			defer cancel()
+			return invoker(ctx, fullMethod, req, reply, cc, opts...)
+		}
+		delay := readRetryDelay
+		for attempt := 1; ; attempt++ {
+			actx, cancel := context.WithTimeout(ctx, timeout)
+			err := invoker(actx, fullMethod, req, reply, cc, opts...)
+			cancel()
+			if err == nil || status.Code(err) != codes.Unavailable || attempt == readAttempts {
+				return err
+			}
+			logging.From(ctx, logger).Warnf("%s unavailable (attempt %d), retrying in %s: %v", method, attempt, delay, err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			}
+			delay *= 2
+		}
+	}
+}
+
+// observeInterceptor counts every unary RPC by method and code, with its retries, observes its
+// latency and logs it at debug level
+func observeInterceptor(logger *logrus.Logger) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		elapsed := time.Since(start)
+		service, method := path.Base(path.Dir(fullMethod)), path.Base(fullMethod)
+		code := status.Code(err)
+		metrics.GRPCClientRequests.WithLabelValues(service, method, code.String()).Inc()
+		metrics.GRPCClientDuration.WithLabelValues(service, method, code.String()).Observe(elapsed.Seconds())
+		if logger.IsLevelEnabled(logrus.DebugLevel) {
+			logging.From(ctx, logger).WithFields(logrus.Fields{
+				"grpc_service": service,
+				"grpc_method":  method,
+				"grpc_code":    code.String(),
+				"duration_ms":  float64(elapsed.Microseconds()) / 1000,
+			}).Debugf("gRPC %s %s in %s", method, code, elapsed)
+		}
+		return err
+	}
+}
@@ -0,0 +1,51 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCallInterceptor(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		results   []codes.Code
+		cancelled bool
+		calls     int
+		code      codes.Code
+	}{
+		{name: "read recovers after retries", method: "ListEntries", results: []codes.Code{codes.Unavailable, codes.Unavailable, codes.OK}, calls: 3, code: codes.OK},
+		{name: "read gives up", method: "GetEntry", results: []codes.Code{codes.Unavailable, codes.Unavailable, codes.Unavailable, codes.OK}, calls: readAttempts, code: codes.Unavailable},
+		{name: "read is not retried on other errors", method: "CountAgents", results: []codes.Code{codes.NotFound, codes.OK}, calls: 1, code: codes.NotFound},
+		{name: "write is not retried", method: "BatchCreateEntry", results: []codes.Code{codes.Unavailable, codes.OK}, calls: 1, code: codes.Unavailable},
+		{name: "read follows the caller", method: "ListAgents", results: []codes.Code{codes.OK}, cancelled: true, calls: 1, code: codes.Canceled},
+		{name: "write outlives the caller", method: "BatchDeleteEntry", results: []codes.Code{codes.OK}, cancelled: true, calls: 1, code: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			invoker := func(ctx context.Context, _ string, _, _ any, _ *grpc.ClientConn, _ ...grpc.CallOption) error {
+				calls++
+				if err := ctx.Err(); err != nil {
+					return status.FromContextError(err).Err()
+				}
+				return status.Error(tt.results[calls-1], "")
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancelled {
+				cancel()
+			}
+			defer cancel()
+			var out bytes.Buffer
+			err := callInterceptor(Timeouts{}, testLogger(&out))(ctx, "/spire.api.server.entry.v1.Entry/"+tt.method, nil, nil, nil, invoker)
+			if calls != tt.calls || status.Code(err) != tt.code {
+				t.Errorf("%d calls ending with %v, want %d ending with %s", calls, err, tt.calls, tt.code)
+			}
+		})
+	}
+}
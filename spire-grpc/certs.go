@@ -0,0 +1,181 @@
+package spire_grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// CertFiles are the client certificate, key and CA bundle of the static certificate mode.
+// Empty fields default to CERT, KEY and CA.
+type CertFiles struct {
+	Cert string `json:"cert,omitempty" yaml:"cert,omitempty"`
+	Key  string `json:"key,omitempty" yaml:"key,omitempty"`
+	CA   string `json:"ca,omitempty" yaml:"ca,omitempty"`
+}
+
+func (cf CertFiles) withDefaults() CertFiles {
+	if cf.Cert == "" {
+		cf.Cert = CERT
+	}
+	if cf.Key == "" {
+		cf.Key = KEY
+	}
+	if cf.CA == "" {
+		cf.CA = CA
+	}
+	return cf
+}
+
+// merge returns cf with its empty fields taken from defaults
+func (cf CertFiles) merge(defaults CertFiles) CertFiles {
+	if cf.Cert == "" {
+		cf.Cert = defaults.Cert
+	}
+	if cf.Key == "" {
+		cf.Key = defaults.Key
+	}
+	if cf.CA == "" {
+		cf.CA = defaults.CA
+	}
+	return cf
+}
+
+// certReloader holds the static client certificate and CA bundle and reloads them when the
+// files change, so rotated certificates are used for new connections without a restart.
+type certReloader struct {
+	files    CertFiles
+	serverID spiffeid.ID
+	logger   *logrus.Logger
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	bundle *x509bundle.Bundle
+
+	watcher *fsnotify.Watcher
+}
+
+func newCertReloader(files CertFiles, serverID spiffeid.ID, logger *logrus.Logger) (*certReloader, error) {
+	cr := &certReloader{files: files, serverID: serverID, logger: logger}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// watch the directories, files replaced by rename (e.g. mounted Kubernetes secrets) drop a file watch
+	dirs := map[string]bool{}
+	for _, f := range []string{files.Cert, files.Key, files.CA} {
+		dirs[filepath.Dir(f)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %s: %v", dir, err)
+		}
+	}
+	cr.watcher = watcher
+	go cr.watch()
+	return cr, nil
+}
+
+// load reads the files, the current certificates are kept if any of them is invalid
+func (cr *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(cr.files.Cert, cr.files.Key)
+	if err != nil {
+		return fmt.Errorf("failed to load client cert: %v", err)
+	}
+	caCert, err := os.ReadFile(cr.files.CA)
+	if err != nil {
+		return fmt.Errorf("failed to read CA file: %v", err)
+	}
+	roots, err := parsePEMCertificates(caCert)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA file %s: %v", cr.files.CA, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse client cert: %v", err)
+		}
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.bundle = x509bundle.FromX509Authorities(cr.serverID.TrustDomain(), roots)
+	cr.mu.Unlock()
+	return nil
+}
+
+func (cr *certReloader) watch() {
+	for {
+		select {
+		case ev, ok := <-cr.watcher.Events:
+			if !ok {
+				return
+			}
+			if !ev.Has(fsnotify.Write) && !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Rename) {
+				continue
+			}
+			if err := cr.load(); err != nil {
+				// a pair of files is rarely written at once, the next event retries
+				cr.logger.Warnf("Failed to reload static certificates after change of %s: %v", ev.Name, err)
+				continue
+			}
+			cr.logger.Infof("Reloaded static certificates after change of %s", ev.Name)
+		case err, ok := <-cr.watcher.Errors:
+			if !ok {
+				return
+			}
+			cr.logger.Errorf("Certificate watcher failed: %v", err)
+		}
+	}
+}
+
+// certificate returns the current client certificate
+func (cr *certReloader) certificate() *tls.Certificate {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert
+}
+
+// verifyPeerCertificate verifies the server's chain against the current CA bundle and checks
+// that the server presents the SPIFFE ID of the SPIRE server.
+func (cr *certReloader) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	cr.mu.RLock()
+	bundle := cr.bundle
+	cr.mu.RUnlock()
+	id, _, err := x509svid.ParseAndVerify(rawCerts, bundle)
+	if err != nil {
+		return fmt.Errorf("failed to verify SPIRE server certificate: %v", err)
+	}
+	if id != cr.serverID {
+		return fmt.Errorf("unexpected SPIRE server ID %q, want %q", id, cr.serverID)
+	}
+	return nil
+}
+
+// tlsConfig returns a client config using the current certificates. The default verification
+// is replaced by verifyPeerCertificate: SPIRE server certificates are SVIDs without DNS names.
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cr.certificate(), nil
+		},
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: cr.verifyPeerCertificate,
+		MinVersion:            tls.VersionTLS12,
+	}
+}
+
+func (cr *certReloader) Close() error {
+	return cr.watcher.Close()
+}
@@ -0,0 +1,124 @@
+package spire_grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Connection modes of a backend
+const (
+	// ModeWorkloadAPI uses mTLS with the spire-api SVID from the Workload API, the default
+	ModeWorkloadAPI = "workload"
+	// ModeAdminSocket uses the SPIRE server's local admin socket, like the spire-server CLI
+	ModeAdminSocket = "admin"
+	// ModeStaticCerts uses TLS with certificate files, for testing and development
+	ModeStaticCerts = "static"
+)
+
+// DefaultAdminSocket is the default socket_path of the SPIRE server
+const DefaultAdminSocket = "/tmp/spire-server/private/api.sock"
+
+// Connector creates a SPIREClient for one way of reaching the SPIRE server. ctx bounds the
+// connection attempt, not the lifetime of the client.
+type Connector interface {
+	Connect(ctx context.Context) (*SPIREClient, error)
+}
+
+// WorkloadAPIConnector connects with mTLS using the SVID from the Workload API at UDSPath
+type WorkloadAPIConnector struct {
+	Servers     []string
+	TrustDomain string
+	UDSPath     string
+	Timeouts    Timeouts
+	Logger      *logrus.Logger
+}
+
+func (wc WorkloadAPIConnector) Connect(ctx context.Context) (*SPIREClient, error) {
+	return NewSpireClient(ctx, wc.Servers, wc.TrustDomain, wc.UDSPath, wc.Timeouts, wc.Logger)
+}
+
+// StaticCertConnector connects with TLS using certificate files, see NewClient
+type StaticCertConnector struct {
+	Server      string
+	TrustDomain string
+	Files       CertFiles
+	Timeouts    Timeouts
+	Logger      *logrus.Logger
+}
+
+func (cc StaticCertConnector) Connect(context.Context) (*SPIREClient, error) {
+	return NewClient(cc.Server, cc.TrustDomain, cc.Files, cc.Timeouts, cc.Logger)
+}
+
+// AdminSocketConnector connects to the SPIRE server's admin socket. Callers on the socket are
+// trusted by the server as local administrators, so no admin entry or mTLS is needed, but
+// spire-api must run on the server host with access to the socket. ServerAddress is only used
+// in generated agent configs.
+type AdminSocketConnector struct {
+	SocketPath    string
+	TrustDomain   string
+	ServerAddress string
+	Timeouts      Timeouts
+	Logger        *logrus.Logger
+}
+
+func (ac AdminSocketConnector) Connect(context.Context) (*SPIREClient, error) {
+	return NewAdminClient(ac.SocketPath, ac.TrustDomain, ac.ServerAddress, ac.Timeouts, ac.Logger)
+}
+
+// NewAdminClient creates a SPIRE client on the server's local admin socket with insecure local credentials
+func NewAdminClient(socketPath string, trustDomain string, serverAddress string, t Timeouts, logger *logrus.Logger) (*SPIREClient, error) {
+	logger = orDefault(logger)
+	if socketPath == "" {
+		socketPath = DefaultAdminSocket
+	}
+	logger.Infof("Creating connection to SPIRE server admin socket: %v", socketPath)
+	conn, err := grpc.NewClient("unix://"+strings.TrimPrefix(socketPath, "unix://"),
+		append(dialOptions(t, logger), grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		logger.Errorf("Failed to create connection to SPIRE server admin socket: %v", err)
+		return nil, err
+	}
+	return newSPIREClient(conn, nil, trustDomain, serverAddress, logger), nil
+}
+
+// ConnectorDefaults are the settings of the command line used where a backend sets none.
+// Logger is the logger of every client.
+type ConnectorDefaults struct {
+	UDSPath  string
+	Certs    CertFiles
+	Timeouts Timeouts
+	Logger   *logrus.Logger
+}
+
+// Connector returns the connector for the backend's mode
+func (b BackendConfig) Connector(d ConnectorDefaults) (Connector, error) {
+	uds := d.UDSPath
+	if b.UDSPath != "" {
+		uds = b.UDSPath
+	}
+	timeouts := b.Timeouts.merge(d.Timeouts)
+	servers := b.Addresses()
+	switch b.Mode {
+	case "", ModeWorkloadAPI:
+		return WorkloadAPIConnector{Servers: servers, TrustDomain: b.TrustDomain, UDSPath: uds, Timeouts: timeouts, Logger: d.Logger}, nil
+	case ModeAdminSocket:
+		ac := AdminSocketConnector{SocketPath: b.AdminSocket, TrustDomain: b.TrustDomain, Timeouts: timeouts, Logger: d.Logger}
+		if len(servers) > 0 {
+			ac.ServerAddress = servers[0]
+		}
+		return ac, nil
+	case ModeStaticCerts:
+		if len(servers) != 1 {
+			return nil, fmt.Errorf("mode static needs exactly one server")
+		}
+		return StaticCertConnector{Server: servers[0], TrustDomain: b.TrustDomain, Files: b.Certs.merge(d.Certs), Timeouts: timeouts, Logger: d.Logger}, nil
+	default:
+		return nil, fmt.Errorf("invalid mode %q", b.Mode)
+	}
+}
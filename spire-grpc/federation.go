@@ -0,0 +1,194 @@
+package spire_grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	bundlepb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/bundle/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+const bundlePageSize = 100
+
+// FederatedBundleInput is a bundle of a foreign trust domain as uploaded to the API.
+// Format is spiffe (SPIFFE bundle JSON) or pem (X.509 authorities only).
+type FederatedBundleInput struct {
+	Format string `json:"format" binding:"required,oneof=spiffe pem"`
+	Bundle string `json:"bundle" binding:"required"`
+}
+
+// ParseFederatedBundle parses and checks a bundle for the trust domain td. Every X.509
+// authority must be currently valid. JWT authorities are not checked for expiry: the keys of a
+// SPIFFE bundle carry none.
+func ParseFederatedBundle(td string, in FederatedBundleInput) (*types.Bundle, error) {
+	trustDomain, err := spiffeid.TrustDomainFromString(td)
+	if err != nil {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "trustDomain", Message: err.Error()}}}
+	}
+	var sb *spiffebundle.Bundle
+	switch in.Format {
+	case BundleFormatSPIFFE:
+		sb, err = spiffebundle.Parse(trustDomain, []byte(in.Bundle))
+		if err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "bundle", Message: err.Error()}}}
+		}
+	case BundleFormatPEM:
+		certs, err := parsePEMCertificates([]byte(in.Bundle))
+		if err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "bundle", Message: err.Error()}}}
+		}
+		sb = spiffebundle.FromX509Authorities(trustDomain, certs)
+	default:
+		return nil, &ValidationError{Fields: []FieldError{{Field: "format", Message: "must be one of spiffe, pem"}}}
+	}
+	if sb.Empty() {
+		return nil, &ValidationError{Fields: []FieldError{{Field: "bundle", Message: "has no authorities"}}}
+	}
+	now := time.Now()
+	for _, cert := range sb.X509Authorities() {
+		if now.After(cert.NotAfter) {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "bundle", Message: fmt.Sprintf("X.509 authority %q expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339))}}}
+		}
+		if now.Before(cert.NotBefore) {
+			return nil, &ValidationError{Fields: []FieldError{{Field: "bundle", Message: fmt.Sprintf("X.509 authority %q is not valid before %s", cert.Subject, cert.NotBefore.Format(time.RFC3339))}}}
+		}
+	}
+	return FromSPIFFEBundle(sb)
+}
+
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found")
+	}
+	return certs, nil
+}
+
+func (sc *SPIREClient) ListFederatedBundles(ctx context.Context) ([]*types.Bundle, error) {
+	req := &bundlepb.ListFederatedBundlesRequest{PageSize: bundlePageSize}
+	var bundles []*types.Bundle
+	for {
+		resp, err := sc.Bundles.ListFederatedBundles(ctx, req)
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to list federated bundles: %v", err)
+			return nil, err
+		}
+		bundles = append(bundles, resp.Bundles...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	return bundles, nil
+}
+
+func (sc *SPIREClient) GetFederatedBundle(ctx context.Context, td string) (*types.Bundle, error) {
+	b, err := sc.Bundles.GetFederatedBundle(ctx, &bundlepb.GetFederatedBundleRequest{TrustDomain: td})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to get federated bundle %s: %v", td, err)
+		return nil, err
+	}
+	return b, nil
+}
+
+// CreateFederatedBundle fails if a bundle for the trust domain already exists
+func (sc *SPIREClient) CreateFederatedBundle(ctx context.Context, b *types.Bundle) (*types.Bundle, error) {
+	sc.log(ctx).Infof("Creating federated bundle %s", b.TrustDomain)
+	resp, err := sc.Bundles.BatchCreateFederatedBundle(ctx, &bundlepb.BatchCreateFederatedBundleRequest{
+		Bundle: []*types.Bundle{b},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to create federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	r := resp.Results[0]
+	if err := statusError(r.Status); err != nil {
+		sc.log(ctx).Errorf("Failed to create federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	return r.Bundle, nil
+}
+
+// UpdateFederatedBundle fails if no bundle for the trust domain exists
+func (sc *SPIREClient) UpdateFederatedBundle(ctx context.Context, b *types.Bundle) (*types.Bundle, error) {
+	sc.log(ctx).Infof("Updating federated bundle %s", b.TrustDomain)
+	resp, err := sc.Bundles.BatchUpdateFederatedBundle(ctx, &bundlepb.BatchUpdateFederatedBundleRequest{
+		Bundle: []*types.Bundle{b},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to update federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	r := resp.Results[0]
+	if err := statusError(r.Status); err != nil {
+		sc.log(ctx).Errorf("Failed to update federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	return r.Bundle, nil
+}
+
+// SetFederatedBundle creates the bundle or replaces an existing one
+func (sc *SPIREClient) SetFederatedBundle(ctx context.Context, b *types.Bundle) (*types.Bundle, error) {
+	sc.log(ctx).Infof("Setting federated bundle %s", b.TrustDomain)
+	resp, err := sc.Bundles.BatchSetFederatedBundle(ctx, &bundlepb.BatchSetFederatedBundleRequest{
+		Bundle: []*types.Bundle{b},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to set federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	r := resp.Results[0]
+	if err := statusError(r.Status); err != nil {
+		sc.log(ctx).Errorf("Failed to set federated bundle %s: %v", b.TrustDomain, err)
+		return nil, err
+	}
+	return r.Bundle, nil
+}
+
+// DeleteFederatedBundle deletes the bundle. mode is restrict (default, fails while entries
+// federate with the trust domain), delete (also deletes those entries) or dissociate.
+func (sc *SPIREClient) DeleteFederatedBundle(ctx context.Context, td string, mode string) error {
+	m := bundlepb.BatchDeleteFederatedBundleRequest_RESTRICT
+	if mode != "" {
+		v, ok := bundlepb.BatchDeleteFederatedBundleRequest_Mode_value[strings.ToUpper(mode)]
+		if !ok {
+			return &ValidationError{Fields: []FieldError{{Field: "mode", Message: "must be one of restrict, delete, dissociate"}}}
+		}
+		m = bundlepb.BatchDeleteFederatedBundleRequest_Mode(v)
+	}
+	sc.log(ctx).Infof("Deleting federated bundle %s (%s)", td, m)
+	resp, err := sc.Bundles.BatchDeleteFederatedBundle(ctx, &bundlepb.BatchDeleteFederatedBundleRequest{
+		TrustDomains: []string{td},
+		Mode:         m,
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to delete federated bundle %s: %v", td, err)
+		return err
+	}
+	if err := statusError(resp.Results[0].Status); err != nil {
+		sc.log(ctx).Errorf("Failed to delete federated bundle %s: %v", td, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package spire_grpc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// testAuthority returns a self-signed CA certificate valid between notBefore and notAfter
+func testAuthority(t *testing.T, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "partner CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func pemOf(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, c := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return b.String()
+}
+
+func TestParseFederatedBundle(t *testing.T) {
+	now := time.Now()
+	valid := testAuthority(t, now.Add(-time.Hour), now.Add(time.Hour))
+	expired := testAuthority(t, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	future := testAuthority(t, now.Add(time.Hour), now.Add(2*time.Hour))
+
+	td := spiffeid.RequireTrustDomainFromString("partner.example.org")
+	sb := spiffebundle.FromX509Authorities(td, []*x509.Certificate{valid})
+	jwtKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err := sb.AddJWTAuthority("key-1", jwtKey.Public()); err != nil {
+		t.Fatal(err)
+	}
+	spiffeJSON, err := sb.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		td          string
+		in          FederatedBundleInput
+		wantField   string
+		wantX509    int
+		wantJWTKeys int
+	}{
+		{name: "pem", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatPEM, Bundle: pemOf(valid)}, wantX509: 1},
+		{name: "spiffe with jwt authority", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatSPIFFE, Bundle: string(spiffeJSON)}, wantX509: 1, wantJWTKeys: 1},
+		{name: "invalid trust domain", td: "Partner!", in: FederatedBundleInput{Format: BundleFormatPEM, Bundle: pemOf(valid)}, wantField: "trustDomain"},
+		{name: "unknown format", td: "partner.example.org", in: FederatedBundleInput{Format: "jwks", Bundle: "{}"}, wantField: "format"},
+		{name: "no certificates", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatPEM, Bundle: "not pem"}, wantField: "bundle"},
+		{name: "expired authority", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatPEM, Bundle: pemOf(valid, expired)}, wantField: "bundle"},
+		{name: "authority not yet valid", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatPEM, Bundle: pemOf(future)}, wantField: "bundle"},
+		{name: "invalid spiffe bundle", td: "partner.example.org", in: FederatedBundleInput{Format: BundleFormatSPIFFE, Bundle: `{"keys": 1}`}, wantField: "bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseFederatedBundle(tt.td, tt.in)
+			if tt.wantField != "" {
+				var ve *ValidationError
+				if !errors.As(err, &ve) || len(ve.Fields) == 0 || ve.Fields[0].Field != tt.wantField {
+					t.Fatalf("ParseFederatedBundle() = %v, want a validation error for %s", err, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFederatedBundle() = %v", err)
+			}
+			if b.TrustDomain != tt.td || len(b.X509Authorities) != tt.wantX509 || len(b.JwtAuthorities) != tt.wantJWTKeys {
+				t.Errorf("bundle = %s with %d X.509 and %d JWT authorities, want %s with %d and %d", b.TrustDomain, len(b.X509Authorities), len(b.JwtAuthorities), tt.td, tt.wantX509, tt.wantJWTKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package spire_grpc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	debugpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/debug/v1"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+type ServerCert struct {
+	SpiffeID  string `json:"spiffeId,omitempty"`
+	Subject   string `json:"subject"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// ServerInfo is the SPIRE server state reported by the debug API. Uptime is in seconds.
+type ServerInfo struct {
+	Uptime                int32        `json:"uptime"`
+	AgentsCount           int32        `json:"agentsCount"`
+	EntriesCount          int32        `json:"entriesCount"`
+	FederatedBundlesCount int32        `json:"federatedBundlesCount"`
+	SVIDChain             []ServerCert `json:"svidChain"`
+}
+
+// HealthCheck is the result of one check of /healthz
+type HealthCheck struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+	// ExpiresAt is set for the X509Source check, the expiry of the spire-api SVID
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+func (sc *SPIREClient) GetServerInfo(ctx context.Context) (*ServerInfo, error) {
+	resp, err := sc.Debug.GetInfo(ctx, &debugpb.GetInfoRequest{})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to get server info: %v", err)
+		return nil, err
+	}
+	info := &ServerInfo{
+		Uptime:                resp.Uptime,
+		AgentsCount:           resp.AgentsCount,
+		EntriesCount:          resp.EntriesCount,
+		FederatedBundlesCount: resp.FederatedBundlesCount,
+		SVIDChain:             []ServerCert{},
+	}
+	for _, cert := range resp.SvidChain {
+		info.SVIDChain = append(info.SVIDChain, ServerCert{
+			SpiffeID:  SPIFFEIDString(cert.Id),
+			Subject:   cert.Subject,
+			ExpiresAt: cert.ExpiresAt,
+		})
+	}
+	return info, nil
+}
+
+// CheckServer runs a gRPC health check against the SPIRE server
+func (sc *SPIREClient) CheckServer(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	resp, err := sc.Health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return HealthCheck{Message: err.Error()}
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		return HealthCheck{Message: resp.Status.String()}
+	}
+	return HealthCheck{Healthy: true}
+}
+
+// CheckX509Source checks that the Workload API source holds an unexpired SVID, or that the
+// static client certificate is unexpired. Clients on the admin socket always pass.
+func (sc *SPIREClient) CheckX509Source() HealthCheck {
+	if sc.certs != nil {
+		expiresAt := sc.certs.certificate().Leaf.NotAfter
+		if time.Now().After(expiresAt) {
+			return HealthCheck{Message: fmt.Sprintf("client certificate %s expired", sc.certs.files.Cert), ExpiresAt: expiresAt.Unix()}
+		}
+		return HealthCheck{Healthy: true, ExpiresAt: expiresAt.Unix()}
+	}
+	if sc.X509Source == nil {
+		return HealthCheck{Healthy: true, Message: "no Workload API source"}
+	}
+	svid, err := sc.X509Source.GetX509SVID()
+	if err != nil {
+		return HealthCheck{Message: err.Error()}
+	}
+	expiresAt := svid.Certificates[0].NotAfter
+	if time.Now().After(expiresAt) {
+		return HealthCheck{Message: fmt.Sprintf("SVID %s expired", svid.ID), ExpiresAt: expiresAt.Unix()}
+	}
+	return HealthCheck{Healthy: true, ExpiresAt: expiresAt.Unix()}
+}
+
+// Close closes the connection to the SPIRE server and the Workload API source
+func (sc *SPIREClient) Close() error {
+	err := sc.GRPCConn.Close()
+	if sc.pinnedConn != nil {
+		if perr := sc.pinnedConn.Close(); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	if sc.X509Source != nil {
+		if serr := sc.X509Source.Close(); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if sc.certs != nil {
+		if cerr := sc.certs.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"spire-api/redact"
+	"strings"
+	"text/template"
+
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+const (
+	DefaultJoinTokenTTL = 600
+	joinTokenPathPrefix = "/spire/agent/join_token/"
+)
+
+// WorkloadEntry is an entry parented to a join token agent. Selectors are "type:value" pairs.
+type WorkloadEntry struct {
+	SpiffeID   string   `json:"spiffeId" binding:"required"`
+	Selectors  []string `json:"selectors" binding:"required,min=1"`
+	Admin      bool     `json:"admin,omitempty"`
+	Downstream bool     `json:"downstream,omitempty"`
+	DNSNames   []string `json:"dnsNames,omitempty"`
+}
+
+type JoinTokenResult struct {
+	Token     string   `json:"token"`
+	ExpiresAt int64    `json:"expiresAt"`
+	AgentID   string   `json:"agentId"`
+	EntryIDs  []string `json:"entryIds,omitempty"`
+	AgentConf string   `json:"agentConf"`
+}
+
+// agentConfTemplate is the default agent.conf, see ParseAgentConfTemplate for its fields
+var agentConfTemplate = template.Must(template.New("agent.conf").Parse(`agent {
+    trust_domain = "{{ .TrustDomain }}"
+{{- if .TrustBundleURL }}
+    trust_bundle_url = "{{ .TrustBundleURL }}"
+{{- else }}
+    # set trust_bundle_url or trust_bundle_path
+{{- end }}
+    data_dir = "/opt/spire/.data"
+    log_level = "INFO"
+    server_address = "{{ .ServerHost }}"
+    server_port = "{{ .ServerPort }}"
+    socket_path = "/tmp/spire-agent/public/api.sock"
+    join_token = "{{ .Token }}"
+}
+
+plugins {
+    KeyManager "disk" {
+        plugin_data {
+            directory = "/opt/spire/.data"
+        }
+    }
+    NodeAttestor "join_token" {
+        plugin_data {
+        }
+    }
+    WorkloadAttestor "unix" {
+        plugin_data {
+        }
+    }
+}
+`))
+
+// CreateJoinToken creates a join token. If agentID is set the agent gets that SPIFFE ID,
+// otherwise SPIRE assigns spiffe://<trust domain>/spire/agent/join_token/<token>.
+func (sc *SPIREClient) CreateJoinToken(ctx context.Context, ttl int32, agentID *types.SPIFFEID) (*types.JoinToken, *types.SPIFFEID, error) {
+	if ttl <= 0 {
+		ttl = DefaultJoinTokenTTL
+	}
+	sc.log(ctx).Infof("Creating join token with ttl %ds", ttl)
+	token, err := sc.Agents.CreateJoinToken(ctx, &agentpb.CreateJoinTokenRequest{
+		Ttl:     ttl,
+		AgentId: agentID,
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to create join token: %v", err)
+		return nil, nil, err
+	}
+	if agentID == nil {
+		agentID = &types.SPIFFEID{
+			TrustDomain: sc.TrustDomain,
+			Path:        joinTokenPathPrefix + token.Value,
+		}
+	}
+	return token, agentID, nil
+}
+
+// ParseSelector converts a "type:value" selector to the API type
+func ParseSelector(s string) (*types.Selector, error) {
+	t, v, ok := strings.Cut(s, ":")
+	if !ok || t == "" || v == "" {
+		return nil, fmt.Errorf("%q must be type:value", s)
+	}
+	return &types.Selector{Type: t, Value: v}, nil
+}
+
+// CreateWorkloadEntries creates the entries parented to parentID and returns the IDs of the
+// entries that were created. Every result is checked, the first failed entry is returned as
+// the error.
+func (sc *SPIREClient) CreateWorkloadEntries(ctx context.Context, parentID *types.SPIFFEID, entries []WorkloadEntry) ([]string, error) {
+	req := &entrypb.BatchCreateEntryRequest{}
+	for i, we := range entries {
+		id, err := ParseSPIFFEID(we.SpiffeID)
+		if err != nil {
+			return nil, &ValidationError{Fields: []FieldError{{Field: fmt.Sprintf("entries[%d].spiffeId", i), Message: err.Error()}}}
+		}
+		entry := &types.Entry{
+			ParentId:   parentID,
+			SpiffeId:   id,
+			Admin:      we.Admin,
+			Downstream: we.Downstream,
+			DnsNames:   we.DNSNames,
+		}
+		for _, s := range we.Selectors {
+			sel, err := ParseSelector(s)
+			if err != nil {
+				return nil, &ValidationError{Fields: []FieldError{{Field: fmt.Sprintf("entries[%d].selectors", i), Message: err.Error()}}}
+			}
+			entry.Selectors = append(entry.Selectors, sel)
+		}
+		req.Entries = append(req.Entries, entry)
+	}
+	if len(req.Entries) == 0 {
+		return nil, nil
+	}
+	resp, err := sc.Client.BatchCreateEntry(ctx, req)
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to create entries: %v", err)
+		return nil, err
+	}
+	var ids []string
+	var failed error
+	for i, r := range resp.Results {
+		if err := statusError(r.Status); err != nil {
+			sc.log(ctx).Errorf("Failed to create entry %s: %v", entries[i].SpiffeID, err)
+			if failed == nil {
+				failed = fmt.Errorf("failed to create entry %s: %w", entries[i].SpiffeID, err)
+			}
+			continue
+		}
+		ids = append(ids, r.Entry.Id)
+	}
+	sc.log(ctx).Infof("Created %d of %d entries for %s", len(ids), len(entries), redact.SPIFFEID(SPIFFEIDString(parentID)))
+	return ids, failed
+}
+
+// ParseAgentConfTemplate reads a text/template replacing the default agent.conf. It is executed
+// with the fields TrustDomain, TrustBundleURL, ServerHost, ServerPort and Token.
+func ParseAgentConfTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Option("missingkey=error").ParseFiles(path)
+}
+
+// AgentConf renders an agent.conf for a join token agent of this server
+func (sc *SPIREClient) AgentConf(token string, trustBundleURL string) (string, error) {
+	tmpl := agentConfTemplate
+	if sc.AgentConfTemplate != nil {
+		tmpl = sc.AgentConfTemplate
+	}
+	host, port, err := net.SplitHostPort(sc.ServerAddress)
+	if err != nil {
+		host, port = sc.ServerAddress, "8081"
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{
+		"TrustDomain":    sc.TrustDomain,
+		"TrustBundleURL": trustBundleURL,
+		"ServerHost":     host,
+		"ServerPort":     port,
+		"Token":          token,
+	})
+	return buf.String(), err
+}
@@ -1,85 +1,141 @@
 package spire_grpc
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"spire-api/metrics"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
 const (
 	k8sPsatConfigFile   = "k8s_psat.json"
 	k8sBundleConfigFile = "k8s_bundle.json"
+
+	// pluginConfigMode is the mode of the k8s_psat and k8s_bundle configs. They hold kubeconfig
+	// paths, not credentials, and the SPIRE server may run as another user.
+	pluginConfigMode os.FileMode = 0644
 )
 
-func (sc *SPIREClient) GetK8sPsatConfig(e *Entry) (*K8SPSATConfig, error) {
+// PsatConfigPath returns the path of the k8s_psat config file for the entry's SPIRE dir
+func (sc *SPIREClient) PsatConfigPath(e *Entry) string {
+	return filepath.Join(e.SpireDir, k8sPsatConfigFile)
+}
+
+// KubeconfigPath returns the path of the plaintext kubeconfig read by the SPIRE server.
+// With encryption enabled this is the decrypted copy in the runtime dir.
+func (sc *SPIREClient) KubeconfigPath(e *Entry) string {
+	if sc.KubeconfigCrypto != nil {
+		return sc.KubeconfigCrypto.runtimePath(e.Cluster)
+	}
+	return filepath.Join(e.SpireDir, "kubeconfigs", e.Cluster+".yaml")
+}
+
+// StoredKubeconfigPath returns the path of the kubeconfig kept in the SPIRE dir, encrypted if enabled
+func (sc *SPIREClient) StoredKubeconfigPath(e *Entry) string {
+	p := filepath.Join(e.SpireDir, "kubeconfigs", e.Cluster+".yaml")
+	if sc.KubeconfigCrypto != nil {
+		p += sealedExt
+	}
+	return p
+}
+
+func (sc *SPIREClient) GetK8sPsatConfig(ctx context.Context, e *Entry) (*K8SPSATConfig, error) {
 	// Read the k8s_psat config file and return the parsed K8SPSATConfig struct
-	sc.Logger.Infof("Reading k8s_psat config file")
-	data, err := os.ReadFile(filepath.Join(e.SpireDir, k8sPsatConfigFile))
+	sc.log(ctx).Infof("Reading k8s_psat config file")
+	data, err := os.ReadFile(sc.PsatConfigPath(e))
 	if err != nil {
-		sc.Logger.Errorf("Failed to read k8s_psat config file: %v", err)
+		sc.log(ctx).Errorf("Failed to read k8s_psat config file: %v", err)
 		return nil, err
 	}
 	k8spsat := &K8SPSATConfig{}
 	err = json.Unmarshal(data, k8spsat)
 	if err != nil {
-		sc.Logger.Errorf("Failed to unmarshal k8s_psat config file: %v", err)
+		sc.log(ctx).Errorf("Failed to unmarshal k8s_psat config file: %v", err)
 		return nil, err
 	}
 	return k8spsat, nil
 }
 
-func (sc *SPIREClient) GetK8sBundleConfig(e *Entry) (*K8SBundleConfig, error) {
+// countPsatWriteFailure counts a failed update of the k8s_psat config
+func (sc *SPIREClient) countPsatWriteFailure(operation string, err *error) {
+	if *err != nil {
+		metrics.PSATConfigWriteFailures.WithLabelValues(sc.Name, operation).Inc()
+	}
+}
+
+// CountK8sPsatClusters returns the number of clusters in the k8s_psat config of spireDir.
+// It does not log, it is called on every metrics scrape.
+func (sc *SPIREClient) CountK8sPsatClusters(spireDir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(spireDir, k8sPsatConfigFile))
+	if err != nil {
+		return 0, err
+	}
+	k8spsat := &K8SPSATConfig{}
+	if err := json.Unmarshal(data, k8spsat); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, clusters := range k8spsat.Clusters {
+		n += len(clusters)
+	}
+	return n, nil
+}
+
+func (sc *SPIREClient) GetK8sBundleConfig(ctx context.Context, e *Entry) (*K8SBundleConfig, error) {
 	// Read the k8s_bundle config file and return the parsed K8SBundleConfig struct
-	sc.Logger.Infof("Reading k8s_bundle config file")
+	sc.log(ctx).Infof("Reading k8s_bundle config file")
 	data, err := os.ReadFile(filepath.Join(e.SpireDir, k8sBundleConfigFile))
 	if err != nil {
-		sc.Logger.Errorf("Failed to read k8s_bundle config file: %v", err)
+		sc.log(ctx).Errorf("Failed to read k8s_bundle config file: %v", err)
 		return nil, err
 	}
 	k8sBundle := &K8SBundleConfig{}
 	err = json.Unmarshal(data, k8sBundle)
 	if err != nil {
-		sc.Logger.Errorf("Failed to unmarshal k8s_bundle config file: %v", err)
+		sc.log(ctx).Errorf("Failed to unmarshal k8s_bundle config file: %v", err)
 		return nil, err
 	}
 	return k8sBundle, nil
 }
 
-func (sc *SPIREClient) MakePSATCluster(e *Entry) *PSATCluster {
-	sc.Logger.Infof("Creating PSATCluster instance")
-	kc := e.Cluster + ".yaml"
+func (sc *SPIREClient) MakePSATCluster(ctx context.Context, e *Entry) *PSATCluster {
+	sc.log(ctx).Infof("Creating PSATCluster instance")
 	pc := &PSATCluster{
 		ServiceAccountAllowList: []string{"spire:spire-agent"},
-		KubeConfigFile:          filepath.Join(e.SpireDir, "kubeconfigs", kc),
+		KubeConfigFile:          sc.KubeconfigPath(e),
 	}
 	return pc
 }
 
-func (sc *SPIREClient) MakeK8sBundleCluster(e *Entry) *BundleCluster {
-	sc.Logger.Infof("Creating BundleCluster instance")
-	kc := e.Cluster + ".yaml"
+func (sc *SPIREClient) MakeK8sBundleCluster(ctx context.Context, e *Entry) *BundleCluster {
+	sc.log(ctx).Infof("Creating BundleCluster instance")
 	bc := &BundleCluster{
-		KubeConfigFilePath: filepath.Join(e.SpireDir, "kubeconfigs", kc),
+		KubeConfigFilePath: sc.KubeconfigPath(e),
 	}
 	return bc
 }
 
-func (sc *SPIREClient) AddK8sPsat(e *Entry) error {
-	currentPsat, err := sc.GetK8sPsatConfig(e)
+func (sc *SPIREClient) AddK8sPsat(ctx context.Context, e *Entry) (err error) {
+	defer sc.countPsatWriteFailure("add", &err)
+	currentPsat, err := sc.GetK8sPsatConfig(ctx, e)
 	if err != nil {
-		sc.Logger.Errorf("Failed to get current k8s_psat config: %v", err)
+		sc.log(ctx).Errorf("Failed to get current k8s_psat config: %v", err)
 		return err
 	}
-	newCluster := sc.MakePSATCluster(e)
+	newCluster := sc.MakePSATCluster(ctx, e)
 	UpdateCluster := false // Flag to check if we need to update an existing cluster
 
 	// Check if cluster already exists in the current configuration
 	//for _, cluster := range currentPsat.Clusters {
 	//	// Check if the cluster already exists in the configuration
 	//	if _, Exists := cluster[e.Cluster]; Exists {
-	//		sc.Logger.Infof("Cluster %s already exists in k8s_psat config, updating it", e.Cluster)
+	//		sc.log(ctx).Infof("Cluster %s already exists in k8s_psat config, updating it", e.Cluster)
 	//		UpdateCluster = true
 	//		// Update the existing cluster's KubeConfigFile if needed
 	//		cluster[e.Cluster] = *newCluster
@@ -87,173 +143,194 @@ func (sc *SPIREClient) AddK8sPsat(e *Entry) error {
 	//}
 
 	if _, exists := currentPsat.Clusters[0][e.Cluster]; exists {
-		sc.Logger.Infof("Cluster %s already exists in k8s_psat config, updating it...", e.Cluster)
+		sc.log(ctx).Infof("Cluster %s already exists in k8s_psat config, updating it...", e.Cluster)
 		UpdateCluster = true
 		currentPsat.Clusters[0][e.Cluster] = *newCluster
 	}
 
 	// Append the new cluster to the existing clusters
 	if currentPsat != nil && UpdateCluster == false {
-		sc.Logger.Infof("Appending new cluster %s to k8s_psat config", e.Cluster)
+		sc.log(ctx).Infof("Appending new cluster %s to k8s_psat config", e.Cluster)
 		currentPsat.Clusters[0][e.Cluster] = *newCluster
 		//currentPsat.Clusters = append(currentPsat.Clusters, map[string]PSATCluster{e.Cluster: *newCluster})
 	}
 	outFile, err := json.MarshalIndent(currentPsat, "", "  ")
 	if err != nil {
-		sc.Logger.Errorf("Failed to marshal updated k8s_psat config: %v", err)
+		sc.log(ctx).Errorf("Failed to marshal updated k8s_psat config: %v", err)
 		return err
 	}
 	// Write the updated config back to file
-	if err := os.WriteFile(filepath.Join(e.SpireDir, k8sPsatConfigFile), outFile, 0644); err != nil {
-		sc.Logger.Errorf("Failed to write updated k8s_psat config file: %v", err)
+	if err := writeFileAtomic(sc.PsatConfigPath(e), outFile, pluginConfigMode); err != nil {
+		sc.log(ctx).Errorf("Failed to write updated k8s_psat config file: %v", err)
 		return err
 	}
-	sc.Logger.Infof("Successfully updated k8s_psat config file")
+	sc.log(ctx).Infof("Successfully updated k8s_psat config file")
 	return nil
 }
 
-func (sc *SPIREClient) AddK8sBundle(e *Entry) error {
-	currentBundle, err := sc.GetK8sBundleConfig(e)
+func (sc *SPIREClient) AddK8sBundle(ctx context.Context, e *Entry) error {
+	currentBundle, err := sc.GetK8sBundleConfig(ctx, e)
 	if err != nil {
-		sc.Logger.Errorf("Failed to get current k8s_bundle config: %v", err)
+		sc.log(ctx).Errorf("Failed to get current k8s_bundle config: %v", err)
 		return err
 	}
-	newCluster := sc.MakeK8sBundleCluster(e)
+	newCluster := sc.MakeK8sBundleCluster(ctx, e)
 	// Append the new cluster to the existing clusters
 
-	if ok := sc.BundleExists(currentBundle, newCluster); ok {
-		sc.Logger.Infof("Cluster %s already exists in k8s_bundle config, skipping update", e.Cluster)
+	if ok := sc.BundleExists(ctx, currentBundle, newCluster); ok {
+		sc.log(ctx).Infof("Cluster %s already exists in k8s_bundle config, skipping update", e.Cluster)
 		return nil
 	}
 
 	if currentBundle != nil {
-		sc.Logger.Infof("Appending new cluster %s to k8s_bundle config", e.Cluster)
+		sc.log(ctx).Infof("Appending new cluster %s to k8s_bundle config", e.Cluster)
 		currentBundle.Clusters = append(currentBundle.Clusters, *newCluster)
 	}
 	outFile, err := json.MarshalIndent(currentBundle, "", "  ")
 	if err != nil {
-		sc.Logger.Errorf("Failed to marshal updated k8s_bundle config: %v", err)
+		sc.log(ctx).Errorf("Failed to marshal updated k8s_bundle config: %v", err)
 		return err
 	}
 	// Write the updated config back to file
-	if err := os.WriteFile(filepath.Join(e.SpireDir, k8sBundleConfigFile), outFile, 0644); err != nil {
-		sc.Logger.Errorf("Failed to write updated k8s_bundle config file: %v", err)
+	if err := writeFileAtomic(filepath.Join(e.SpireDir, k8sBundleConfigFile), outFile, pluginConfigMode); err != nil {
+		sc.log(ctx).Errorf("Failed to write updated k8s_bundle config file: %v", err)
 		return err
 	}
-	sc.Logger.Infof("Successfully updated k8s_bundle config file")
+	sc.log(ctx).Infof("Successfully updated k8s_bundle config file")
 	return nil
 }
 
-func (sc *SPIREClient) WriteKubeconfig(e *Entry) error {
+func (sc *SPIREClient) WriteKubeconfig(ctx context.Context, e *Entry) error {
 	if e.KubeConfig == "" {
-		sc.Logger.Errorf("No KubeConfig provided in entry")
+		sc.log(ctx).Errorf("No KubeConfig provided in entry")
 		return nil
 	}
+	// The cluster name becomes a file name, never let it escape the kubeconfig dir
+	if err := ValidateDNS1123Label(e.Cluster); err != nil {
+		sc.log(ctx).Errorf("Invalid cluster name %q: %v", e.Cluster, err)
+		return err
+	}
 	kcDir := filepath.Join(e.SpireDir, "kubeconfigs")
 	if _, err := os.Stat(kcDir); os.IsNotExist(err) {
-		sc.Logger.Errorf("kubeconfig dir does not exist: %v", kcDir)
+		sc.log(ctx).Errorf("kubeconfig dir does not exist: %v", kcDir)
 		return err
 	}
 	kcBytes, err := base64.StdEncoding.DecodeString(e.KubeConfig)
 
 	if err != nil {
-		sc.Logger.Errorf("Failed to decode KubeConfig: %v", err)
+		sc.log(ctx).Errorf("Failed to decode KubeConfig: %v", err)
 		return err
 	}
+	if sc.KubeconfigCrypto != nil {
+		return sc.writeSealedKubeconfig(ctx, e, kcBytes)
+	}
 
-	kcFile := filepath.Join(kcDir, e.Cluster+".yaml")
+	kcFile := sc.KubeconfigPath(e)
 
 	if _, err := os.Stat(kcFile); err == nil {
 		// Read the content to compare with the new content before overwriting
 		currKcBytes, err := os.ReadFile(kcFile)
 		if err != nil {
-			sc.Logger.Errorf("Failed to read existing KubeConfig file: %v", err)
+			sc.log(ctx).Errorf("Failed to read existing KubeConfig file: %v", err)
 			return err
 		}
 		if base64.StdEncoding.EncodeToString(currKcBytes) == e.KubeConfig {
 			// No change in KubeConfig, skipping write
-			sc.Logger.Infof("No change in KubeConfig, skipping write to file: %v", kcFile)
+			sc.log(ctx).Infof("No change in KubeConfig, skipping write to file: %v", kcFile)
 			return nil
 		}
 	}
 
-	sc.Logger.Infof("Writing KubeConfig to file: %v", kcFile)
-	if err := os.WriteFile(kcFile, kcBytes, 0644); err != nil {
-		sc.Logger.Errorf("Failed to write KubeConfig file: %v", err)
+	sc.log(ctx).Infof("Writing KubeConfig to file: %v", kcFile)
+	// kubeconfigs hold cluster credentials, only the SPIRE server user may read them
+	if err := writeFileAtomic(kcFile, kcBytes, 0600); err != nil {
+		sc.log(ctx).Errorf("Failed to write KubeConfig file: %v", err)
 		return err
 	}
-	sc.Logger.Infof("Successfully wrote KubeConfig file: %v", kcFile)
+	sc.log(ctx).Infof("Successfully wrote KubeConfig file: %v", kcFile)
 	return nil
 }
 
-func (sc *SPIREClient) DeleteKubeconfig(e *Entry) error {
+func (sc *SPIREClient) DeleteKubeconfig(ctx context.Context, e *Entry) error {
+	if err := ValidateDNS1123Label(e.Cluster); err != nil {
+		sc.log(ctx).Errorf("Invalid cluster name %q: %v", e.Cluster, err)
+		return err
+	}
+	if sc.KubeconfigCrypto != nil {
+		// Remove the decrypted copy even if the stored file is already gone
+		if err := os.Remove(sc.KubeconfigPath(e)); err != nil && !os.IsNotExist(err) {
+			sc.log(ctx).Errorf("Failed to delete decrypted KubeConfig file: %v", err)
+			return err
+		}
+	}
 
-	kcFile := filepath.Join(e.SpireDir, "kubeconfigs", e.Cluster+".yaml")
+	kcFile := sc.StoredKubeconfigPath(e)
 	if ok := sc.KubeconfigExists(e); !ok {
-		sc.Logger.Infof("Kubeconfig %s does not exist, skipping deletion", kcFile)
+		sc.log(ctx).Infof("Kubeconfig %s does not exist, skipping deletion", kcFile)
 		return nil
 	}
-	sc.Logger.Infof("Deleting KubeConfig: %v", kcFile)
+	sc.log(ctx).Infof("Deleting KubeConfig: %v", kcFile)
 	if err := os.Remove(kcFile); err != nil {
-		sc.Logger.Errorf("Failed to delete KubeConfig file: %v", err)
+		sc.log(ctx).Errorf("Failed to delete KubeConfig file: %v", err)
 		return err
 	}
 
 	return nil
 }
 
-func (sc *SPIREClient) DeleteK8sPsat(e *Entry) error {
-	currentPsat, err := sc.GetK8sPsatConfig(e)
+func (sc *SPIREClient) DeleteK8sPsat(ctx context.Context, e *Entry) (err error) {
+	defer sc.countPsatWriteFailure("delete", &err)
+	currentPsat, err := sc.GetK8sPsatConfig(ctx, e)
 	//updatedPsat := &K8SPSATConfig{}
 
 	if err != nil {
-		sc.Logger.Errorf("Failed to get current k8s_psat config: %v", err)
+		sc.log(ctx).Errorf("Failed to get current k8s_psat config: %v", err)
 		return err
 	}
 	if ok := sc.PSATClusterExists(e, currentPsat); !ok {
-		sc.Logger.Infof("Cluster %s does not exist in k8s_psat config, skipping deletion", e.Cluster)
+		sc.log(ctx).Infof("Cluster %s does not exist in k8s_psat config, skipping deletion", e.Cluster)
 		return nil
 	}
 	// the logic to create a new slice
 	//for _, cluster := range currentPsat.Clusters {
 	//	if _, ok := cluster[e.Cluster]; ok {
-	//		sc.Logger.Infof("Pre Deleting cluster %s from k8s_psat config slice", e.Cluster)
+	//		sc.log(ctx).Infof("Pre Deleting cluster %s from k8s_psat config slice", e.Cluster)
 	//	} else {
 	//		updatedPsat.Clusters = append(updatedPsat.Clusters, cluster)
 	//	}
 	//}
-	sc.Logger.Infof("Pre Deleting k8s_psat config: %v", e.Cluster)
+	sc.log(ctx).Infof("Pre Deleting k8s_psat config: %v", e.Cluster)
 	delete(currentPsat.Clusters[0], e.Cluster)
 
 	outFile, err := json.MarshalIndent(currentPsat, "", "  ")
 	if err != nil {
-		sc.Logger.Errorf("Failed to marshal updated k8s_psat config: %v", err)
+		sc.log(ctx).Errorf("Failed to marshal updated k8s_psat config: %v", err)
 		return err
 	}
 	// Write the updated config back to file
-	if err := os.WriteFile(filepath.Join(e.SpireDir, k8sPsatConfigFile), outFile, 0644); err != nil {
-		sc.Logger.Errorf("Failed to write updated k8s_psat config file: %v", err)
+	if err := writeFileAtomic(sc.PsatConfigPath(e), outFile, pluginConfigMode); err != nil {
+		sc.log(ctx).Errorf("Failed to write updated k8s_psat config file: %v", err)
 		return err
 	}
-	sc.Logger.Infof("Successfully updated k8s_psat config file")
+	sc.log(ctx).Infof("Successfully updated k8s_psat config file")
 
 	return nil
 }
 
-func (sc *SPIREClient) DeleteK8sBundle(e *Entry) error {
-	currentBundle, err := sc.GetK8sBundleConfig(e)
+func (sc *SPIREClient) DeleteK8sBundle(ctx context.Context, e *Entry) error {
+	currentBundle, err := sc.GetK8sBundleConfig(ctx, e)
 	if err != nil {
-		sc.Logger.Errorf("Failed to get current k8s_bundle config: %v", err)
+		sc.log(ctx).Errorf("Failed to get current k8s_bundle config: %v", err)
 		return err
 	}
 	updatedBundle := &K8SBundleConfig{}
-	if ok := sc.BundleExists(currentBundle, sc.MakeK8sBundleCluster(e)); !ok {
-		sc.Logger.Infof("Cluster %s does not exist in k8s_bundle config, skipping deletion", e.Cluster)
+	if ok := sc.BundleExists(ctx, currentBundle, sc.MakeK8sBundleCluster(ctx, e)); !ok {
+		sc.log(ctx).Infof("Cluster %s does not exist in k8s_bundle config, skipping deletion", e.Cluster)
 		return nil
 	}
 	for _, cluster := range currentBundle.Clusters {
-		if cluster.KubeConfigFilePath == sc.MakeK8sBundleCluster(e).KubeConfigFilePath {
-			sc.Logger.Infof("Pre Deleting cluster %s from k8s_bundle config slice", e.Cluster)
+		if cluster.KubeConfigFilePath == sc.MakeK8sBundleCluster(ctx, e).KubeConfigFilePath {
+			sc.log(ctx).Infof("Pre Deleting cluster %s from k8s_bundle config slice", e.Cluster)
 			continue
 		} else {
 			updatedBundle.Clusters = append(updatedBundle.Clusters, cluster)
@@ -262,47 +339,80 @@ func (sc *SPIREClient) DeleteK8sBundle(e *Entry) error {
 
 	outFile, err := json.MarshalIndent(updatedBundle, "", "  ")
 	if err != nil {
-		sc.Logger.Errorf("Failed to marshal updated k8s_bundle config: %v", err)
+		sc.log(ctx).Errorf("Failed to marshal updated k8s_bundle config: %v", err)
+		return err
 	}
 	// Write the updated config back to file
-	if err := os.WriteFile(filepath.Join(e.SpireDir, k8sBundleConfigFile), outFile, 0644); err != nil {
-		sc.Logger.Errorf("Failed to write updated k8s_bundle config file: %v", err)
+	if err := writeFileAtomic(filepath.Join(e.SpireDir, k8sBundleConfigFile), outFile, pluginConfigMode); err != nil {
+		sc.log(ctx).Errorf("Failed to write updated k8s_bundle config file: %v", err)
 		return err
 	}
 
 	return nil
 }
 
-func (sc *SPIREClient) SigUsr1() error {
-	// Send SIGUSR1 to the SPIRE server process
-	pids, err := findPIDsByName("spire-server")
+func (sc *SPIREClient) SigUsr1(ctx context.Context) error {
+	return sc.signalProcesses(ctx, sc.Reload.ProcessName)
+}
+
+// signalProcesses sends SIGUSR1 to every local process named name, spire-server if empty
+func (sc *SPIREClient) signalProcesses(ctx context.Context, name string) error {
+	if name == "" {
+		name = defaultServerProcess
+	}
+	pids, err := findPIDsByName(name)
 	if err != nil {
-		sc.Logger.Errorf("Failed to find SPIRE server process: %v", err)
+		sc.log(ctx).Errorf("Failed to find SPIRE server process: %v", err)
 		return err
 	}
 	if len(pids) == 0 {
-		sc.Logger.Warn("No SPIRE server process found")
-		return nil
+		sc.log(ctx).Errorf("No %s process found to reload", name)
+		return fmt.Errorf("no %s process found, the SPIRE server runs on another host or in another PID namespace", name)
 	}
+	var failed int
 	for _, pid := range pids {
-		proc, err := os.FindProcess(pid)
-		if err != nil {
-			sc.Logger.Errorf("Failed to find SPIRE server process: %v", err)
-			continue
-		}
-		if err := proc.Signal(syscall.SIGUSR1); err != nil {
-			sc.Logger.Errorf("Failed to send SIGUSR1 to SPIRE server process: %v", err)
-			continue
+		// errors are logged, keep signalling the other processes
+		if err := sc.signalServer(ctx, pid); err != nil {
+			failed++
 		}
-		sc.Logger.Infof("Sent SIGUSR1 to SPIRE server process with PID: %d", pid)
 	}
+	if failed == len(pids) {
+		return fmt.Errorf("failed to signal any of the %d %s processes", len(pids), name)
+	}
+	return nil
+}
+
+func (sc *SPIREClient) signalPIDFile(ctx context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to read SPIRE server pid file: %v", err)
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid in %s: %v", path, err)
+	}
+	return sc.signalServer(ctx, pid)
+}
+
+func (sc *SPIREClient) signalServer(ctx context.Context, pid int) error {
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to find SPIRE server process: %v", err)
+		return err
+	}
+	if err := proc.Signal(syscall.SIGUSR1); err != nil {
+		sc.log(ctx).Errorf("Failed to send SIGUSR1 to SPIRE server process: %v", err)
+		return err
+	}
+	sc.log(ctx).Infof("Sent SIGUSR1 to SPIRE server process with PID: %d", pid)
 	return nil
 }
 
-func (sc *SPIREClient) BundleExists(currBundle *K8SBundleConfig, cl *BundleCluster) bool {
+func (sc *SPIREClient) BundleExists(ctx context.Context, currBundle *K8SBundleConfig, cl *BundleCluster) bool {
 	for _, cluster := range currBundle.Clusters {
 		if cluster.KubeConfigFilePath == cl.KubeConfigFilePath {
-			sc.Logger.Infof("Cluster %s already exists in k8s_bundle config", cl.KubeConfigFilePath)
+			sc.log(ctx).Infof("Cluster %s already exists in k8s_bundle config", cl.KubeConfigFilePath)
 			return true
 		}
 	}
@@ -318,7 +428,7 @@ func (sc *SPIREClient) PSATClusterExists(e *Entry, currPsat *K8SPSATConfig) bool
 }
 
 func (sc *SPIREClient) KubeconfigExists(e *Entry) bool {
-	kcFile := filepath.Join(e.SpireDir, "kubeconfigs", e.Cluster+".yaml")
+	kcFile := sc.StoredKubeconfigPath(e)
 	if _, err := os.Stat(kcFile); err == nil {
 		return true
 	}
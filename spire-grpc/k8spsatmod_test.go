@@ -0,0 +1,51 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPluginConfigWrites(t *testing.T) {
+	spireDir := t.TempDir()
+	psatPath := filepath.Join(spireDir, k8sPsatConfigFile)
+	bundlePath := filepath.Join(spireDir, k8sBundleConfigFile)
+	writePsatConfig(t, psatPath, map[string]PSATCluster{"existing": {KubeConfigFile: "/etc/kubeconfigs/existing.yaml"}})
+	if err := os.WriteFile(bundlePath, []byte(`{"clusters":[]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sc := &SPIREClient{Logger: testLogger(&out)}
+	e := &Entry{SpireDir: spireDir, Cluster: "ambient-a"}
+	ctx := context.Background()
+
+	steps := []struct {
+		name string
+		path string
+		run  func() error
+	}{
+		{name: "add psat", path: psatPath, run: func() error { return sc.AddK8sPsat(ctx, e) }},
+		{name: "add bundle", path: bundlePath, run: func() error { return sc.AddK8sBundle(ctx, e) }},
+		{name: "delete psat", path: psatPath, run: func() error { return sc.DeleteK8sPsat(ctx, e) }},
+		{name: "delete bundle", path: bundlePath, run: func() error { return sc.DeleteK8sBundle(ctx, e) }},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		// the configs start as 0600, every write sets the same mode
+		fi, err := os.Stat(step.path)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if fi.Mode().Perm() != pluginConfigMode {
+			t.Errorf("after %s %s has mode %v, want %v", step.name, filepath.Base(step.path), fi.Mode().Perm(), pluginConfigMode)
+		}
+		// the temp files are renamed over the configs, none is left behind
+		if tmps, _ := filepath.Glob(filepath.Join(spireDir, ".*.tmp*")); len(tmps) > 0 {
+			t.Errorf("after %s temp files are left: %v", step.name, tmps)
+		}
+	}
+}
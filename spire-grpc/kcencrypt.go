@@ -0,0 +1,501 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+)
+
+const (
+	sealedExt       = ".enc"
+	sealedVersion   = 1
+	sealerTypeLocal = "local"
+	sealerTypeAge   = "age"
+	localKeyLength  = 32
+)
+
+// KubeconfigEncryption configures encryption of stored kubeconfigs.
+// Exactly one of KeyFile and AgeIdentityFile enables encryption, none keeps plaintext files.
+type KubeconfigEncryption struct {
+	// KeyFile holds a 32 byte AES key, raw or base64 encoded
+	KeyFile string
+	// AgeIdentityFile holds an age X25519 identity (AGE-SECRET-KEY-...)
+	AgeIdentityFile string
+	// OldKeyFiles are previous key or identity files, used to decrypt and re-encrypt after a key change
+	OldKeyFiles []string
+	// RuntimeDir receives the decrypted 0600 copies read by the SPIRE server. It should be on tmpfs.
+	RuntimeDir string
+}
+
+// sealer encrypts and decrypts kubeconfigs with one key
+type sealer interface {
+	Type() string
+	KeyID() string
+	Seal(plain []byte) (*sealedFile, error)
+	Open(f *sealedFile) ([]byte, error)
+}
+
+// sealedFile is the on-disk format of an encrypted kubeconfig
+type sealedFile struct {
+	Version    int    `json:"version"`
+	Type       string `json:"type"`
+	KeyID      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+type KubeconfigCrypto struct {
+	current    sealer
+	old        []sealer
+	runtimeDir string
+}
+
+// NewKubeconfigCrypto loads the configured keys. It returns nil when encryption is disabled.
+func NewKubeconfigCrypto(cfg KubeconfigEncryption) (*KubeconfigCrypto, error) {
+	if cfg.KeyFile != "" && cfg.AgeIdentityFile != "" {
+		return nil, fmt.Errorf("only one of the kubeconfig key file and age identity can be set")
+	}
+	var current sealer
+	var err error
+	switch {
+	case cfg.KeyFile != "":
+		current, err = loadSealer(cfg.KeyFile)
+	case cfg.AgeIdentityFile != "":
+		current, err = loadSealer(cfg.AgeIdentityFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RuntimeDir == "" {
+		return nil, fmt.Errorf("a runtime dir is required for encrypted kubeconfigs")
+	}
+	kc := &KubeconfigCrypto{
+		current:    current,
+		runtimeDir: cfg.RuntimeDir,
+	}
+	for _, f := range cfg.OldKeyFiles {
+		s, err := loadSealer(f)
+		if err != nil {
+			return nil, err
+		}
+		kc.old = append(kc.old, s)
+	}
+	return kc, nil
+}
+
+// loadSealer detects the key type from the file content
+func loadSealer(path string) (sealer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if strings.Contains(text, "AGE-SECRET-KEY-") {
+		identities, err := age.ParseIdentities(strings.NewReader(text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age identity %s: %v", path, err)
+		}
+		id, ok := identities[0].(*age.X25519Identity)
+		if !ok {
+			return nil, fmt.Errorf("age identity %s is not an X25519 identity", path)
+		}
+		return &ageSealer{identity: id}, nil
+	}
+	key := data
+	if len(key) != localKeyLength {
+		if key, err = base64.StdEncoding.DecodeString(text); err != nil || len(key) != localKeyLength {
+			return nil, fmt.Errorf("key file %s must hold %d bytes, raw or base64 encoded", path, localKeyLength)
+		}
+	}
+	return &localSealer{key: key}, nil
+}
+
+// open decrypts f with the current or an old key
+func (kc *KubeconfigCrypto) open(f *sealedFile) ([]byte, error) {
+	for _, s := range append([]sealer{kc.current}, kc.old...) {
+		if s.Type() == f.Type && s.KeyID() == f.KeyID {
+			return s.Open(f)
+		}
+	}
+	return nil, fmt.Errorf("no key available for %s key %s", f.Type, f.KeyID)
+}
+
+// runtimePath returns the path of the decrypted copy for a cluster
+func (kc *KubeconfigCrypto) runtimePath(cluster string) string {
+	return filepath.Join(kc.runtimeDir, cluster+".yaml")
+}
+
+func readSealedFile(path string) (*sealedFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &sealedFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	if f.Version != sealedVersion {
+		return nil, fmt.Errorf("unsupported sealed kubeconfig version %d", f.Version)
+	}
+	return f, nil
+}
+
+func writeSealedFile(path string, f *sealedFile) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+// writeFileAtomic writes to a temp file in the same dir and renames it over path, so a reader
+// or a crash never sees a partly written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// localSealer wraps a random data key per file with a local AES-256 key
+type localSealer struct {
+	key []byte
+}
+
+func (s *localSealer) Type() string {
+	return sealerTypeLocal
+}
+
+func (s *localSealer) KeyID() string {
+	sum := sha256.Sum256(s.key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *localSealer) Seal(plain []byte) (*sealedFile, error) {
+	dek := make([]byte, localKeyLength)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, err
+	}
+	nonce, ct, err := gcmSeal(dek, plain)
+	if err != nil {
+		return nil, err
+	}
+	wrapNonce, wrapped, err := gcmSeal(s.key, dek)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedFile{
+		Version: sealedVersion,
+		Type:    s.Type(),
+		KeyID:   s.KeyID(),
+		// the wrapped key carries its own nonce in front
+		WrappedKey: base64.StdEncoding.EncodeToString(append(wrapNonce, wrapped...)),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ct),
+	}, nil
+}
+
+func (s *localSealer) Open(f *sealedFile) ([]byte, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(f.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(f.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := base64.StdEncoding.DecodeString(f.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < 12 {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	dek, err := gcmOpen(s.key, wrapped[:12], wrapped[12:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	return gcmOpen(dek, nonce, ct)
+}
+
+func gcmSeal(key, plain []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plain, nil), nil
+}
+
+func gcmOpen(key, nonce, ct []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, ct, nil)
+}
+
+// ageSealer encrypts to the recipient of an age X25519 identity. age already uses a per-file key.
+type ageSealer struct {
+	identity *age.X25519Identity
+}
+
+func (s *ageSealer) Type() string {
+	return sealerTypeAge
+}
+
+func (s *ageSealer) KeyID() string {
+	return s.identity.Recipient().String()
+}
+
+func (s *ageSealer) Seal(plain []byte) (*sealedFile, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, s.identity.Recipient())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &sealedFile{
+		Version:    sealedVersion,
+		Type:       s.Type(),
+		KeyID:      s.KeyID(),
+		Ciphertext: base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+func (s *ageSealer) Open(f *sealedFile) ([]byte, error) {
+	ct, err := base64.StdEncoding.DecodeString(f.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(ct), s.identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (sc *SPIREClient) writeSealedKubeconfig(ctx context.Context, e *Entry, kcBytes []byte) error {
+	kc := sc.KubeconfigCrypto
+	storedFile := sc.StoredKubeconfigPath(e)
+	runtimeFile := sc.KubeconfigPath(e)
+
+	if f, err := readSealedFile(storedFile); err == nil {
+		// Compare with the stored content before overwriting
+		curr, err := kc.open(f)
+		if err == nil && bytes.Equal(curr, kcBytes) && f.KeyID == kc.current.KeyID() {
+			sc.log(ctx).Infof("No change in KubeConfig, skipping write to file: %v", storedFile)
+			return sc.writeRuntimeKubeconfig(ctx, runtimeFile, kcBytes)
+		}
+	}
+
+	sealed, err := kc.current.Seal(kcBytes)
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to encrypt KubeConfig: %v", err)
+		return err
+	}
+	sc.log(ctx).Infof("Writing encrypted KubeConfig to file: %v", storedFile)
+	if err := writeSealedFile(storedFile, sealed); err != nil {
+		sc.log(ctx).Errorf("Failed to write encrypted KubeConfig file: %v", err)
+		return err
+	}
+	return sc.writeRuntimeKubeconfig(ctx, runtimeFile, kcBytes)
+}
+
+func (sc *SPIREClient) writeRuntimeKubeconfig(ctx context.Context, path string, kcBytes []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		sc.log(ctx).Errorf("Failed to create kubeconfig runtime dir: %v", err)
+		return err
+	}
+	if err := writeFileAtomic(path, kcBytes, 0600); err != nil {
+		sc.log(ctx).Errorf("Failed to write decrypted KubeConfig file: %v", err)
+		return err
+	}
+	sc.log(ctx).Infof("Successfully wrote decrypted KubeConfig file: %v", path)
+	return nil
+}
+
+// SyncKubeconfigs prepares the stored kubeconfigs in spireDir for the SPIRE server when encryption
+// is enabled. Plaintext kubeconfigs are encrypted and removed, files sealed with an old key are
+// re-encrypted with the current key, and a decrypted copy of each is written to the runtime dir.
+// The k8s_psat config is updated to point the clusters with a stored kubeconfig to the decrypted
+// copies. Like any config change, this is applied to the SPIRE dir of each replica, and replicas
+// whose k8s_psat config changed are reloaded.
+func (sc *SPIREClient) SyncKubeconfigs(ctx context.Context, spireDir string) error {
+	if sc.KubeconfigCrypto == nil {
+		return nil
+	}
+	_, err := sc.ApplyToReplicas(ctx, &Entry{SpireDir: spireDir}, func(ctx context.Context, e *Entry) error {
+		return sc.syncKubeconfigDir(ctx, e.SpireDir)
+	}, nil)
+	return err
+}
+
+// syncKubeconfigDir syncs the kubeconfigs of one SPIRE dir, it returns errUnchanged if the
+// k8s_psat config already points to the decrypted copies
+func (sc *SPIREClient) syncKubeconfigDir(ctx context.Context, spireDir string) error {
+	kcDir := filepath.Join(spireDir, "kubeconfigs")
+	files, err := os.ReadDir(kcDir)
+	if os.IsNotExist(err) {
+		sc.log(ctx).Warnf("kubeconfig dir does not exist: %v", kcDir)
+		return errUnchanged
+	}
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to read kubeconfig dir: %v", err)
+		return err
+	}
+	var clusters []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		e := &Entry{SpireDir: spireDir}
+		switch {
+		case strings.HasSuffix(name, ".yaml"+sealedExt):
+			e.Cluster = strings.TrimSuffix(name, ".yaml"+sealedExt)
+			err = sc.resealKubeconfig(ctx, e)
+		case strings.HasSuffix(name, ".yaml"):
+			e.Cluster = strings.TrimSuffix(name, ".yaml")
+			err = sc.sealPlaintextKubeconfig(ctx, e, filepath.Join(kcDir, name))
+		default:
+			continue
+		}
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to sync kubeconfig for cluster %s: %v", e.Cluster, err)
+			return err
+		}
+		clusters = append(clusters, e.Cluster)
+	}
+	sc.log(ctx).Infof("Synced %d encrypted kubeconfigs", len(clusters))
+	return sc.pointPsatToRuntimeDir(ctx, spireDir, clusters)
+}
+
+func (sc *SPIREClient) resealKubeconfig(ctx context.Context, e *Entry) error {
+	kc := sc.KubeconfigCrypto
+	f, err := readSealedFile(sc.StoredKubeconfigPath(e))
+	if err != nil {
+		return err
+	}
+	plain, err := kc.open(f)
+	if err != nil {
+		return err
+	}
+	if f.Type != kc.current.Type() || f.KeyID != kc.current.KeyID() {
+		sc.log(ctx).Infof("Re-encrypting kubeconfig for cluster %s with key %s", e.Cluster, kc.current.KeyID())
+		sealed, err := kc.current.Seal(plain)
+		if err != nil {
+			return err
+		}
+		if err := writeSealedFile(sc.StoredKubeconfigPath(e), sealed); err != nil {
+			return err
+		}
+	}
+	return sc.writeRuntimeKubeconfig(ctx, sc.KubeconfigPath(e), plain)
+}
+
+func (sc *SPIREClient) sealPlaintextKubeconfig(ctx context.Context, e *Entry, path string) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sc.log(ctx).Infof("Encrypting plaintext kubeconfig for cluster %s", e.Cluster)
+	sealed, err := sc.KubeconfigCrypto.current.Seal(plain)
+	if err != nil {
+		return err
+	}
+	if err := writeSealedFile(sc.StoredKubeconfigPath(e), sealed); err != nil {
+		return err
+	}
+	if err := sc.writeRuntimeKubeconfig(ctx, sc.KubeconfigPath(e), plain); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// pointPsatToRuntimeDir points the k8s_psat clusters with a stored kubeconfig to the decrypted
+// copies, other clusters are kept. It returns errUnchanged if no cluster changed.
+func (sc *SPIREClient) pointPsatToRuntimeDir(ctx context.Context, spireDir string, clusters []string) error {
+	e := &Entry{SpireDir: spireDir}
+	currentPsat, err := sc.GetK8sPsatConfig(ctx, e)
+	if err != nil {
+		return err
+	}
+	if len(currentPsat.Clusters) == 0 {
+		return errUnchanged
+	}
+	changed := false
+	for _, name := range clusters {
+		cluster, ok := currentPsat.Clusters[0][name]
+		if !ok {
+			continue
+		}
+		if runtimeFile := sc.KubeconfigCrypto.runtimePath(name); cluster.KubeConfigFile != runtimeFile {
+			cluster.KubeConfigFile = runtimeFile
+			currentPsat.Clusters[0][name] = cluster
+			changed = true
+		}
+	}
+	if !changed {
+		return errUnchanged
+	}
+	outFile, err := json.MarshalIndent(currentPsat, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(sc.PsatConfigPath(e), outFile, pluginConfigMode); err != nil {
+		sc.log(ctx).Errorf("Failed to write updated k8s_psat config file: %v", err)
+		return err
+	}
+	sc.log(ctx).Infof("Updated k8s_psat config to use decrypted kubeconfigs in %s", sc.KubeconfigCrypto.runtimeDir)
+	return nil
+}
@@ -0,0 +1,90 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePsatConfig(t *testing.T, path string, clusters map[string]PSATCluster) {
+	t.Helper()
+	data, err := json.Marshal(K8SPSATConfig{Clusters: []map[string]PSATCluster{clusters}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncKubeconfigs(t *testing.T) {
+	dir := t.TempDir()
+	spireDir, runtimeDir := filepath.Join(dir, "spire"), filepath.Join(dir, "run")
+	os.MkdirAll(filepath.Join(spireDir, "kubeconfigs"), 0700)
+	key := make([]byte, 32)
+	rand.Read(key)
+	keyFile := filepath.Join(dir, "kubeconfig.key")
+	os.WriteFile(keyFile, key, 0600)
+	kc, err := NewKubeconfigCrypto(KubeconfigEncryption{KeyFile: keyFile, RuntimeDir: runtimeDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ambient-a has a stored kubeconfig, external reads one that spire-api does not manage
+	plainA := filepath.Join(spireDir, "kubeconfigs", "ambient-a.yaml")
+	os.WriteFile(plainA, []byte("token: a"), 0600)
+	psatPath := filepath.Join(spireDir, k8sPsatConfigFile)
+	writePsatConfig(t, psatPath, map[string]PSATCluster{
+		"ambient-a": {KubeConfigFile: plainA},
+		"external":  {KubeConfigFile: "/etc/kubeconfigs/external.yaml"},
+	})
+
+	reloads := filepath.Join(dir, "reloads")
+	var out bytes.Buffer
+	sc := &SPIREClient{
+		Logger:           testLogger(&out),
+		KubeconfigCrypto: kc,
+		Reload:           ReloadConfig{Strategy: ReloadExec, Command: []string{"sh", "-c", "echo reload >> " + reloads}},
+	}
+	ctx := context.Background()
+	if err := sc.SyncKubeconfigs(ctx, spireDir); err != nil {
+		t.Fatalf("SyncKubeconfigs: %v", err)
+	}
+
+	psat, err := sc.GetK8sPsatConfig(ctx, &Entry{SpireDir: spireDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := psat.Clusters[0]["ambient-a"].KubeConfigFile, filepath.Join(runtimeDir, "ambient-a.yaml"); got != want {
+		t.Errorf("ambient-a kube_config_file = %q, want %q", got, want)
+	}
+	if got := psat.Clusters[0]["external"].KubeConfigFile; got != "/etc/kubeconfigs/external.yaml" {
+		t.Errorf("external kube_config_file = %q, want it unchanged", got)
+	}
+	for path, mode := range map[string]os.FileMode{psatPath: pluginConfigMode, filepath.Join(runtimeDir, "ambient-a.yaml"): 0600, plainA + sealedExt: 0600} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("%s has mode %v, want %v", path, fi.Mode().Perm(), mode)
+		}
+	}
+	if _, err := os.Stat(plainA); !os.IsNotExist(err) {
+		t.Errorf("plaintext kubeconfig was not removed: %v", err)
+	}
+
+	// nothing changes on the second sync, the server is not reloaded again
+	if err := sc.SyncKubeconfigs(ctx, spireDir); err != nil {
+		t.Fatalf("second SyncKubeconfigs: %v", err)
+	}
+	data, _ := os.ReadFile(reloads)
+	if n := strings.Count(string(data), "reload"); n != 1 {
+		t.Errorf("server reloaded %d times, want 1", n)
+	}
+}
@@ -0,0 +1,98 @@
+package spire_grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// connectAttemptTimeout bounds one attempt to get an SVID from the Workload API
+	connectAttemptTimeout = 15 * time.Second
+	connectBaseDelay      = time.Second
+	connectMaxDelay       = 30 * time.Second
+)
+
+// dialOptions are the RPC traces, metrics and timeouts, keepalive and reconnect settings of every SPIRE server connection.
+// The keepalive time is the grpc-go server's minimum, SPIRE servers close connections pinging more often.
+func dialOptions(t Timeouts, logger *logrus.Logger) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(observeInterceptor(logger), callInterceptor(t, logger)),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    5 * time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  connectBaseDelay,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   connectMaxDelay,
+			},
+			MinConnectTimeout: 10 * time.Second,
+		}),
+	}
+}
+
+// ConnectWithRetry connects with c until it succeeds or ctx is done, backing off exponentially
+// between attempts. At boot the Workload API socket may not exist yet or the agent may not have
+// the spire-api SVID, both resolve on their own. Other errors, such as missing certificate files
+// or an invalid trust domain, are returned at once.
+func ConnectWithRetry(ctx context.Context, name string, c Connector, logger *logrus.Logger) (*SPIREClient, error) {
+	delay := connectBaseDelay
+	for attempt := 1; ; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, connectAttemptTimeout)
+		sc, err := c.Connect(actx)
+		cancel()
+		if err == nil {
+			return sc, nil
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("gave up connecting to %s after %d attempts: %w", name, attempt, ctx.Err())
+		}
+		if !retryable(err) {
+			return nil, fmt.Errorf("failed to connect to %s: %w", name, err)
+		}
+		logger.Warnf("Failed to connect to %s (attempt %d), retrying in %s: %v", name, attempt, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting to %s after %d attempts: %w", name, attempt, ctx.Err())
+		}
+		delay *= 2
+		if delay > connectMaxDelay {
+			delay = connectMaxDelay
+		}
+	}
+}
+
+// retryable reports whether a connection error may resolve on its own: the server or the
+// Workload API is unavailable, could not be dialed or did not answer within the attempt.
+func retryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		switch s.Code() {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return true
+		}
+	}
+	return false
+}
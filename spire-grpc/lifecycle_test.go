@@ -0,0 +1,78 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server unavailable", err: status.Error(codes.Unavailable, "connection refused"), want: true},
+		{name: "attempt timed out", err: fmt.Errorf("waiting for SVID: %w", context.DeadlineExceeded), want: true},
+		{name: "dial error", err: &net.OpError{Op: "dial", Net: "unix", Err: syscall.ENOENT}, want: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{name: "missing certificate file", err: fmt.Errorf("failed to load client cert: %w", fs.ErrNotExist), want: false},
+		{name: "invalid trust domain", err: errors.New("trust domain characters are limited to lowercase letters"), want: false},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "no identity issued"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeConnector fails with errs in turn, then connects
+type fakeConnector struct {
+	errs     []error
+	attempts int
+}
+
+func (f *fakeConnector) Connect(context.Context) (*SPIREClient, error) {
+	f.attempts++
+	if f.attempts <= len(f.errs) {
+		return nil, f.errs[f.attempts-1]
+	}
+	return &SPIREClient{}, nil
+}
+
+func TestConnectWithRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		fails    bool
+	}{
+		{name: "connects", attempts: 1},
+		{name: "retries an unavailable server", errs: []error{status.Error(codes.Unavailable, "connection refused")}, attempts: 2},
+		{name: "fails fast on a permanent error", errs: []error{errors.New(`invalid trust domain "Example.org"`)}, attempts: 1, fails: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := &fakeConnector{errs: tt.errs}
+			_, err := ConnectWithRetry(context.Background(), "prod", c, testLogger(&out))
+			if (err != nil) != tt.fails {
+				t.Errorf("error = %v, want failure %v", err, tt.fails)
+			}
+			if c.attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", c.attempts, tt.attempts)
+			}
+		})
+	}
+}
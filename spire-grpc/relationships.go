@@ -0,0 +1,193 @@
+package spire_grpc
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	trustdomainpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/trustdomain/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+// Bundle endpoint profiles of a federation relationship
+const (
+	ProfileHTTPSWeb    = "https_web"
+	ProfileHTTPSSPIFFE = "https_spiffe"
+)
+
+// FederationRelationship is a relationship with a foreign trust domain whose bundle the SPIRE
+// server fetches from BundleEndpointURL. EndpointSPIFFEID is required for https_spiffe,
+// which also needs TrustDomainBundle (or an existing federated bundle) to authenticate the endpoint.
+type FederationRelationship struct {
+	TrustDomain       string                `json:"trustDomain" binding:"required"`
+	BundleEndpointURL string                `json:"bundleEndpointUrl" binding:"required"`
+	Profile           string                `json:"profile" binding:"required,oneof=https_web https_spiffe"`
+	EndpointSPIFFEID  string                `json:"endpointSpiffeId,omitempty"`
+	TrustDomainBundle *FederatedBundleInput `json:"trustDomainBundle,omitempty"`
+}
+
+// ValidateFederationRelationship checks the relationship against this server and converts it to the API type
+func (sc *SPIREClient) ValidateFederationRelationship(fr *FederationRelationship) (*types.FederationRelationship, error) {
+	ve := &ValidationError{}
+	if _, err := spiffeid.TrustDomainFromString(fr.TrustDomain); err != nil {
+		ve.add("trustDomain", err.Error())
+	} else if fr.TrustDomain == sc.TrustDomain {
+		ve.add("trustDomain", "must be a foreign trust domain")
+	}
+	if u, err := url.Parse(fr.BundleEndpointURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		ve.add("bundleEndpointUrl", "must be an https URL")
+	}
+	out := &types.FederationRelationship{
+		TrustDomain:       fr.TrustDomain,
+		BundleEndpointUrl: fr.BundleEndpointURL,
+	}
+	switch fr.Profile {
+	case ProfileHTTPSWeb:
+		if fr.EndpointSPIFFEID != "" {
+			ve.add("endpointSpiffeId", "is only allowed with the https_spiffe profile")
+		}
+		out.BundleEndpointProfile = &types.FederationRelationship_HttpsWeb{HttpsWeb: &types.HTTPSWebProfile{}}
+	case ProfileHTTPSSPIFFE:
+		if fr.EndpointSPIFFEID == "" {
+			ve.add("endpointSpiffeId", "is required with the https_spiffe profile")
+		} else if _, err := spiffeid.FromString(fr.EndpointSPIFFEID); err != nil {
+			ve.add("endpointSpiffeId", err.Error())
+		}
+		out.BundleEndpointProfile = &types.FederationRelationship_HttpsSpiffe{HttpsSpiffe: &types.HTTPSSPIFFEProfile{EndpointSpiffeId: fr.EndpointSPIFFEID}}
+	default:
+		ve.add("profile", "must be one of https_web, https_spiffe")
+	}
+	if len(ve.Fields) > 0 {
+		return nil, ve
+	}
+	if fr.TrustDomainBundle != nil {
+		b, err := ParseFederatedBundle(fr.TrustDomain, *fr.TrustDomainBundle)
+		if err != nil {
+			if bve, ok := err.(*ValidationError); ok {
+				for i := range bve.Fields {
+					bve.Fields[i].Field = "trustDomainBundle." + bve.Fields[i].Field
+				}
+			}
+			return nil, err
+		}
+		out.TrustDomainBundle = b
+	}
+	return out, nil
+}
+
+// ToFederationRelationship converts an API relationship, the bundle is returned as SPIFFE bundle JSON
+func ToFederationRelationship(r *types.FederationRelationship) (*FederationRelationship, error) {
+	fr := &FederationRelationship{
+		TrustDomain:       r.TrustDomain,
+		BundleEndpointURL: r.BundleEndpointUrl,
+	}
+	switch {
+	case r.GetHttpsWeb() != nil:
+		fr.Profile = ProfileHTTPSWeb
+	case r.GetHttpsSpiffe() != nil:
+		fr.Profile = ProfileHTTPSSPIFFE
+		fr.EndpointSPIFFEID = r.GetHttpsSpiffe().EndpointSpiffeId
+	}
+	if r.TrustDomainBundle != nil {
+		data, err := MarshalBundle(r.TrustDomainBundle, BundleFormatSPIFFE)
+		if err != nil {
+			return nil, err
+		}
+		fr.TrustDomainBundle = &FederatedBundleInput{Format: BundleFormatSPIFFE, Bundle: string(data)}
+	}
+	return fr, nil
+}
+
+func (sc *SPIREClient) ListFederationRelationships(ctx context.Context) ([]*types.FederationRelationship, error) {
+	req := &trustdomainpb.ListFederationRelationshipsRequest{PageSize: bundlePageSize}
+	var relationships []*types.FederationRelationship
+	for {
+		resp, err := sc.TrustDomains.ListFederationRelationships(ctx, req)
+		if err != nil {
+			sc.log(ctx).Errorf("Failed to list federation relationships: %v", err)
+			return nil, err
+		}
+		relationships = append(relationships, resp.FederationRelationships...)
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	return relationships, nil
+}
+
+func (sc *SPIREClient) GetFederationRelationship(ctx context.Context, td string) (*types.FederationRelationship, error) {
+	r, err := sc.TrustDomains.GetFederationRelationship(ctx, &trustdomainpb.GetFederationRelationshipRequest{TrustDomain: td})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to get federation relationship %s: %v", td, err)
+		return nil, err
+	}
+	return r, nil
+}
+
+func (sc *SPIREClient) CreateFederationRelationship(ctx context.Context, r *types.FederationRelationship) (*types.FederationRelationship, error) {
+	sc.log(ctx).Infof("Creating federation relationship with %s via %s", r.TrustDomain, r.BundleEndpointUrl)
+	resp, err := sc.TrustDomains.BatchCreateFederationRelationship(ctx, &trustdomainpb.BatchCreateFederationRelationshipRequest{
+		FederationRelationships: []*types.FederationRelationship{r},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to create federation relationship %s: %v", r.TrustDomain, err)
+		return nil, err
+	}
+	res := resp.Results[0]
+	if err := statusError(res.Status); err != nil {
+		sc.log(ctx).Errorf("Failed to create federation relationship %s: %v", r.TrustDomain, err)
+		return nil, err
+	}
+	return res.FederationRelationship, nil
+}
+
+// UpdateFederationRelationship replaces the endpoint URL and profile, and the bundle if r carries one
+func (sc *SPIREClient) UpdateFederationRelationship(ctx context.Context, r *types.FederationRelationship) (*types.FederationRelationship, error) {
+	sc.log(ctx).Infof("Updating federation relationship with %s via %s", r.TrustDomain, r.BundleEndpointUrl)
+	resp, err := sc.TrustDomains.BatchUpdateFederationRelationship(ctx, &trustdomainpb.BatchUpdateFederationRelationshipRequest{
+		FederationRelationships: []*types.FederationRelationship{r},
+		InputMask: &types.FederationRelationshipMask{
+			BundleEndpointUrl:     true,
+			BundleEndpointProfile: true,
+			TrustDomainBundle:     r.TrustDomainBundle != nil,
+		},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to update federation relationship %s: %v", r.TrustDomain, err)
+		return nil, err
+	}
+	res := resp.Results[0]
+	if err := statusError(res.Status); err != nil {
+		sc.log(ctx).Errorf("Failed to update federation relationship %s: %v", r.TrustDomain, err)
+		return nil, err
+	}
+	return res.FederationRelationship, nil
+}
+
+// DeleteFederationRelationship deletes the relationship, the federated bundle is kept
+func (sc *SPIREClient) DeleteFederationRelationship(ctx context.Context, td string) error {
+	sc.log(ctx).Infof("Deleting federation relationship with %s", td)
+	resp, err := sc.TrustDomains.BatchDeleteFederationRelationship(ctx, &trustdomainpb.BatchDeleteFederationRelationshipRequest{
+		TrustDomains: []string{td},
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to delete federation relationship %s: %v", td, err)
+		return err
+	}
+	if err := statusError(resp.Results[0].Status); err != nil {
+		sc.log(ctx).Errorf("Failed to delete federation relationship %s: %v", td, err)
+		return err
+	}
+	return nil
+}
+
+// RefreshBundle makes the SPIRE server fetch the bundle of td from its bundle endpoint now
+func (sc *SPIREClient) RefreshBundle(ctx context.Context, td string) error {
+	sc.log(ctx).Infof("Refreshing bundle of %s", td)
+	if _, err := sc.TrustDomains.RefreshBundle(ctx, &trustdomainpb.RefreshBundleRequest{TrustDomain: td}); err != nil {
+		sc.log(ctx).Errorf("Failed to refresh bundle of %s: %v", td, err)
+		return err
+	}
+	return nil
+}
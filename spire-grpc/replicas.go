@@ -0,0 +1,246 @@
+package spire_grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"spire-api/apierror"
+	"spire-api/metrics"
+	"spire-api/tracing"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc"
+	// registers the client side health checking used by healthCheckConfig
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+)
+
+// errUnchanged is returned by the apply func of ApplyToReplicas when there is nothing to write
+var errUnchanged = errors.New("config unchanged")
+
+const (
+	replicaScheme      = "spire-replicas"
+	reloadExecTimeout  = 30 * time.Second
+	replicaServiceConf = `{
+  "loadBalancingConfig": [{"round_robin": {}}],
+  "healthCheckConfig": {"serviceName": ""}
+}`
+	pinnedServiceConf = `{"loadBalancingConfig": [{"pick_first": {}}]}`
+)
+
+// Replica outcomes, per replica of a backend
+const (
+	ReplicaOK      = "ok"
+	ReplicaFailed  = "failed"
+	ReplicaSkipped = "skipped"
+)
+
+// ReplicaConfig is one SPIRE server of an HA backend. SpireDir defaults to the backend's
+// (a shared config volume, written once), Reload defaults to the backend's reload.
+type ReplicaConfig struct {
+	Address  string       `json:"address" yaml:"address"`
+	SpireDir string       `json:"spireDir,omitempty" yaml:"spireDir,omitempty"`
+	Reload   ReloadConfig `json:"reload,omitempty" yaml:"reload,omitempty"`
+}
+
+// ReplicaResult confirms a config change on one replica: its SPIRE dir was written and read
+// back, and it was reloaded.
+type ReplicaResult struct {
+	Address  string `json:"address"`
+	SpireDir string `json:"spireDir"`
+	Written  bool   `json:"written"`
+	Reload   string `json:"reload"`
+	Error    string `json:"error,omitempty"`
+}
+
+// dialTarget returns the gRPC target for the servers. Several servers are balanced round robin
+// over a static resolver, and the health checks of the SPIRE servers take unhealthy ones out.
+func dialTarget(servers []string) (string, []grpc.DialOption) {
+	return staticTarget(servers, replicaServiceConf)
+}
+
+// pinnedDialTarget returns the gRPC target for the RPCs about one SPIRE server rather than the
+// shared datastore: its local authorities, debug info and health. They all go to the first
+// reachable server and only move on when it fails, so e.g. a prepared authority is activated on
+// the replica that prepared it.
+func pinnedDialTarget(servers []string) (string, []grpc.DialOption) {
+	return staticTarget(servers, pinnedServiceConf)
+}
+
+func staticTarget(servers []string, serviceConf string) (string, []grpc.DialOption) {
+	if len(servers) == 1 {
+		return servers[0], nil
+	}
+	r := manual.NewBuilderWithScheme(replicaScheme)
+	var addrs []resolver.Address
+	for _, s := range servers {
+		addrs = append(addrs, resolver.Address{Addr: s})
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+	return replicaScheme + ":///spire-server", []grpc.DialOption{
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(serviceConf),
+	}
+}
+
+// replicas returns the replicas with defaults applied, a single replica for a non HA backend
+func (sc *SPIREClient) replicas(e *Entry) []ReplicaConfig {
+	if len(sc.Replicas) == 0 {
+		return []ReplicaConfig{{Address: sc.ServerAddress, SpireDir: e.SpireDir, Reload: sc.Reload}}
+	}
+	out := make([]ReplicaConfig, len(sc.Replicas))
+	for i, r := range sc.Replicas {
+		if r.SpireDir == "" {
+			r.SpireDir = e.SpireDir
+		}
+		if r.Reload.Strategy == "" {
+			r.Reload = sc.Reload
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// ConfigFiles returns the k8s_psat config and stored kubeconfig of e in every replica SPIRE dir
+func (sc *SPIREClient) ConfigFiles(e *Entry) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, r := range sc.replicas(e) {
+		if seen[r.SpireDir] {
+			continue
+		}
+		seen[r.SpireDir] = true
+		re := *e
+		re.SpireDir = r.SpireDir
+		files = append(files, sc.PsatConfigPath(&re), sc.StoredKubeconfigPath(&re))
+	}
+	return files
+}
+
+// ApplyToReplicas runs apply and then verify once for each distinct replica SPIRE dir, with
+// e.SpireDir set to that dir, and reloads every replica whose dir was written. apply may be nil
+// to only reload. apply returns errUnchanged when the dir already holds the change, its replicas
+// are then confirmed without a reload. Each step is traced in a child span of ctx. It returns a
+// config_write_failed error if the config of any replica was not written, or else a reload_failed
+// error if any reload failed.
+func (sc *SPIREClient) ApplyToReplicas(ctx context.Context, e *Entry, apply func(ctx context.Context, e *Entry) error, verify func(ctx context.Context, e *Entry) error) ([]ReplicaResult, error) {
+	dirErrs := map[string]error{}
+	unchanged := map[string]bool{}
+	var results []ReplicaResult
+	var failed []string
+	code := apierror.ReloadFailed
+	for _, r := range sc.replicas(e) {
+		err, done := dirErrs[r.SpireDir]
+		if !done && apply != nil {
+			re := *e
+			re.SpireDir = r.SpireDir
+			dir := attribute.String("spire.dir", r.SpireDir)
+			err = tracing.Step(ctx, "config.apply", func(ctx context.Context) error {
+				err := apply(ctx, &re)
+				if errors.Is(err, errUnchanged) {
+					unchanged[r.SpireDir] = true
+					return nil
+				}
+				return err
+			}, dir)
+			if err == nil && verify != nil && !unchanged[r.SpireDir] {
+				err = tracing.Step(ctx, "config.verify", func(ctx context.Context) error { return verify(ctx, &re) }, dir)
+			}
+			dirErrs[r.SpireDir] = err
+		}
+		res := ReplicaResult{Address: r.Address, SpireDir: r.SpireDir, Written: err == nil, Reload: ReplicaSkipped}
+		if err == nil && !unchanged[r.SpireDir] {
+			reloaded, rerr := sc.reload(ctx, r)
+			switch {
+			case rerr != nil:
+				res.Reload, err = ReplicaFailed, rerr
+			case reloaded:
+				res.Reload = ReplicaOK
+			}
+		}
+		if err != nil {
+			res.Error = err.Error()
+			failed = append(failed, r.Address)
+			if !res.Written {
+				code = apierror.ConfigWriteFailed
+			}
+			sc.log(ctx).Errorf("Config change not confirmed on SPIRE server %s: %v", r.Address, err)
+		}
+		results = append(results, res)
+	}
+	if len(failed) > 0 {
+		return results, apierror.New(code, "config change not confirmed on %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// VerifyK8sPsat reads the k8s_psat config back and checks that the cluster of e is present
+// with its kubeconfig, or absent.
+func (sc *SPIREClient) VerifyK8sPsat(ctx context.Context, e *Entry, present bool) error {
+	psat, err := sc.GetK8sPsatConfig(ctx, e)
+	if err != nil {
+		return err
+	}
+	exists := sc.PSATClusterExists(e, psat)
+	switch {
+	case present && !exists:
+		return fmt.Errorf("cluster %s missing from %s after write", e.Cluster, sc.PsatConfigPath(e))
+	case !present && exists:
+		return fmt.Errorf("cluster %s still in %s after delete", e.Cluster, sc.PsatConfigPath(e))
+	case present && e.KubeConfig != "":
+		if _, err := os.Stat(sc.StoredKubeconfigPath(e)); err != nil {
+			return fmt.Errorf("kubeconfig missing after write: %v", err)
+		}
+	}
+	return nil
+}
+
+// reload tells one SPIRE server to re-read its config, it returns false if the strategy is none
+func (sc *SPIREClient) reload(ctx context.Context, r ReplicaConfig) (bool, error) {
+	rc := r.Reload
+	if rc.Strategy == ReloadNone {
+		sc.log(ctx).Info("Reload strategy is none, skipping SPIRE server reload")
+		return false, nil
+	}
+	strategy := rc.Strategy
+	if strategy == "" {
+		strategy = ReloadSignal
+	}
+	start := time.Now()
+	err := tracing.Step(ctx, "spire.reload", func(ctx context.Context) error {
+		return sc.reloadServer(ctx, rc)
+	}, attribute.String("spire.server", r.Address), attribute.String("spire.reload.strategy", strategy))
+	outcome := ReplicaOK
+	if err != nil {
+		outcome = ReplicaFailed
+	}
+	metrics.Reloads.WithLabelValues(sc.Name, strategy, outcome).Inc()
+	metrics.ReloadDuration.WithLabelValues(sc.Name, strategy).Observe(time.Since(start).Seconds())
+	return true, err
+}
+
+func (sc *SPIREClient) reloadServer(ctx context.Context, rc ReloadConfig) error {
+	switch rc.Strategy {
+	case ReloadPIDFile:
+		return sc.signalPIDFile(ctx, rc.PIDFile)
+	case ReloadExec:
+		// the command is not cancelled with the request, like writes to the SPIRE server
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reloadExecTimeout)
+		defer cancel()
+		out, err := exec.CommandContext(ctx, rc.Command[0], rc.Command[1:]...).CombinedOutput()
+		if err != nil {
+			sc.log(ctx).Errorf("Reload command %s failed: %v: %s", filepath.Base(rc.Command[0]), err, strings.TrimSpace(string(out)))
+			return fmt.Errorf("reload command failed: %v", err)
+		}
+		sc.log(ctx).Infof("Reload command %s succeeded", filepath.Base(rc.Command[0]))
+		return nil
+	default:
+		return sc.signalProcesses(ctx, rc.ProcessName)
+	}
+}
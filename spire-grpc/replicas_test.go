@@ -0,0 +1,79 @@
+package spire_grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	localauthoritypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/localauthority/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// countingAuthority counts the authority state RPCs served by one replica
+type countingAuthority struct {
+	localauthoritypb.UnimplementedLocalAuthorityServer
+	mu    sync.Mutex
+	calls int
+}
+
+func (a *countingAuthority) GetX509AuthorityState(context.Context, *localauthoritypb.GetX509AuthorityStateRequest) (*localauthoritypb.GetX509AuthorityStateResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls++
+	return &localauthoritypb.GetX509AuthorityStateResponse{}, nil
+}
+
+func startReplica(t *testing.T) (string, *countingAuthority) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &countingAuthority{}
+	s := grpc.NewServer()
+	localauthoritypb.RegisterLocalAuthorityServer(s, a)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return lis.Addr().String(), a
+}
+
+func TestDialTargets(t *testing.T) {
+	tests := []struct {
+		name   string
+		target func(servers []string) (string, []grpc.DialOption)
+		// replicas is the number of replicas that must serve the calls
+		replicas int
+	}{
+		{name: "datastore RPCs are balanced", target: dialTarget, replicas: 2},
+		{name: "per-server RPCs are pinned", target: pinnedDialTarget, replicas: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr1, a1 := startReplica(t)
+			addr2, a2 := startReplica(t)
+			target, opts := tt.target([]string{addr1, addr2})
+			conn, err := grpc.NewClient(target, append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			client := localauthoritypb.NewLocalAuthorityClient(conn)
+			for i := 0; i < 50; i++ {
+				if _, err := client.GetX509AuthorityState(context.Background(), &localauthoritypb.GetX509AuthorityStateRequest{}, grpc.WaitForReady(true)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			served := 0
+			for _, a := range []*countingAuthority{a1, a2} {
+				if a.calls > 0 {
+					served++
+				}
+			}
+			if served != tt.replicas {
+				t.Errorf("%d replicas served the calls (%d, %d), want %d", served, a1.calls, a2.calls, tt.replicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package spire_grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Rotation steps, run in this order
+const (
+	RotationStepPrepare   = "prepare"
+	RotationStepPropagate = "propagate"
+	RotationStepActivate  = "activate"
+	RotationStepTaint     = "taint"
+)
+
+// Rotation and step statuses
+const (
+	RotationPending   = "pending"
+	RotationRunning   = "running"
+	RotationSucceeded = "succeeded"
+	RotationFailed    = "failed"
+	RotationCancelled = "cancelled"
+	RotationSkipped   = "skipped"
+)
+
+const (
+	defaultRotationPollInterval = 30 * time.Second
+	defaultRotationTimeout      = 2 * time.Hour
+	rotationPendingAgentsShown  = 10
+)
+
+var errStepSkipped = errors.New("step skipped")
+
+// RotationOptions start a guided rotation. PollInterval and Timeout are in seconds.
+type RotationOptions struct {
+	Type         string `json:"type" binding:"required,oneof=x509 jwt"`
+	PollInterval int    `json:"pollInterval,omitempty" binding:"gte=0"`
+	Timeout      int    `json:"timeout,omitempty" binding:"gte=0"`
+}
+
+type RotationStep struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	StartedAt  int64  `json:"startedAt,omitempty"`
+	FinishedAt int64  `json:"finishedAt,omitempty"`
+}
+
+// Rotation is the state of a guided rotation. AgentsSynced counts the agents that have
+// fetched the bundle with the prepared authority.
+type Rotation struct {
+	ID                  string         `json:"id"`
+	Type                string         `json:"type"`
+	Status              string         `json:"status"`
+	OldAuthorityID      string         `json:"oldAuthorityId,omitempty"`
+	PreparedAuthorityID string         `json:"preparedAuthorityId,omitempty"`
+	AgentsTotal         int            `json:"agentsTotal"`
+	AgentsSynced        int            `json:"agentsSynced"`
+	Steps               []RotationStep `json:"steps"`
+	StartedAt           int64          `json:"startedAt"`
+	FinishedAt          int64          `json:"finishedAt,omitempty"`
+}
+
+func (r *Rotation) copy() *Rotation {
+	c := *r
+	c.Steps = append([]RotationStep(nil), r.Steps...)
+	return &c
+}
+
+// Rotator runs one guided rotation at a time: prepare a new authority, wait until every agent
+// has the bundle with it, activate it and taint the old one.
+type Rotator struct {
+	sc      *SPIREClient
+	mu      sync.Mutex
+	current *Rotation
+	cancel  context.CancelFunc
+	// wg tracks the goroutine of the running rotation
+	wg sync.WaitGroup
+	// serials are the agent SVID serial numbers when the authority was prepared
+	serials map[string]string
+}
+
+func NewRotator(sc *SPIREClient) *Rotator {
+	return &Rotator{sc: sc}
+}
+
+// Current returns a copy of the running or last rotation, nil if none was started
+func (rt *Rotator) Current() *Rotation {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.current == nil {
+		return nil
+	}
+	return rt.current.copy()
+}
+
+// Start begins a rotation in the background. onStep is called after each step finishes.
+func (rt *Rotator) Start(opts RotationOptions, onStep func(r *Rotation, step RotationStep)) (*Rotation, error) {
+	if opts.Type != AuthorityX509 && opts.Type != AuthorityJWT {
+		return nil, invalidAuthorityType()
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.current != nil && rt.current.Status == RotationRunning {
+		return nil, status.Errorf(codes.AlreadyExists, "rotation %s is already running", rt.current.ID)
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	r := &Rotation{
+		ID:        hex.EncodeToString(b),
+		Type:      opts.Type,
+		Status:    RotationRunning,
+		StartedAt: time.Now().Unix(),
+	}
+	for _, name := range []string{RotationStepPrepare, RotationStepPropagate, RotationStepActivate, RotationStepTaint} {
+		r.Steps = append(r.Steps, RotationStep{Name: name, Status: RotationPending})
+	}
+	poll := defaultRotationPollInterval
+	if opts.PollInterval > 0 {
+		poll = time.Duration(opts.PollInterval) * time.Second
+	}
+	timeout := defaultRotationTimeout
+	if opts.Timeout > 0 {
+		timeout = time.Duration(opts.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	rt.current, rt.cancel = r, cancel
+	rt.sc.Logger.Infof("Starting %s authority rotation %s", r.Type, r.ID)
+	rt.wg.Add(1)
+	go func() {
+		defer rt.wg.Done()
+		defer cancel()
+		rt.run(ctx, r, poll, onStep)
+	}()
+	return r.copy(), nil
+}
+
+// Cancel stops the running rotation. Steps already done are not undone.
+func (rt *Rotator) Cancel() (*Rotation, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.current == nil || rt.current.Status != RotationRunning {
+		return nil, status.Error(codes.NotFound, "no rotation is running")
+	}
+	rt.cancel()
+	rt.finish(rt.current, RotationCancelled)
+	rt.sc.Logger.Warnf("Cancelled authority rotation %s", rt.current.ID)
+	return rt.current.copy(), nil
+}
+
+// Stop cancels the running rotation, if any, and waits until its current step has returned
+func (rt *Rotator) Stop() {
+	if _, err := rt.Cancel(); err == nil {
+		rt.sc.Logger.Warn("Authority rotation cancelled by shutdown")
+	}
+	rt.wg.Wait()
+}
+
+// finish sets the final status, callers hold mu
+func (rt *Rotator) finish(r *Rotation, s string) {
+	r.Status = s
+	r.FinishedAt = time.Now().Unix()
+	for i := range r.Steps {
+		if r.Steps[i].Status == RotationRunning {
+			r.Steps[i].Status = s
+			r.Steps[i].FinishedAt = r.FinishedAt
+		}
+	}
+}
+
+func (rt *Rotator) run(ctx context.Context, r *Rotation, poll time.Duration, onStep func(r *Rotation, step RotationStep)) {
+	steps := []func(ctx context.Context, r *Rotation, poll time.Duration) (string, error){
+		rt.prepare, rt.propagate, rt.activate, rt.taint,
+	}
+	for i, step := range steps {
+		if !rt.setStep(r, i, RotationRunning, "") {
+			return
+		}
+		msg, err := step(ctx, r, poll)
+		s := RotationSucceeded
+		switch {
+		case err == errStepSkipped:
+			s, err = RotationSkipped, nil
+		case err != nil:
+			s, msg = RotationFailed, err.Error()
+		}
+		if !rt.setStep(r, i, s, msg) {
+			return
+		}
+		rt.mu.Lock()
+		snapshot, done := r.copy(), r.Steps[i]
+		rt.mu.Unlock()
+		if onStep != nil {
+			onStep(snapshot, done)
+		}
+		if err != nil {
+			rt.sc.Logger.Errorf("Authority rotation %s failed at %s: %v", r.ID, done.Name, err)
+			rt.mu.Lock()
+			rt.finish(r, RotationFailed)
+			rt.mu.Unlock()
+			return
+		}
+	}
+	rt.mu.Lock()
+	rt.finish(r, RotationSucceeded)
+	rt.mu.Unlock()
+	rt.sc.Logger.Infof("Authority rotation %s succeeded, %s is active", r.ID, r.PreparedAuthorityID)
+}
+
+// setStep updates step i, it returns false if the rotation was cancelled
+func (rt *Rotator) setStep(r *Rotation, i int, s string, msg string) bool {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if r.Status != RotationRunning {
+		return false
+	}
+	now := time.Now().Unix()
+	if s == RotationRunning {
+		r.Steps[i].StartedAt = now
+	} else {
+		r.Steps[i].FinishedAt = now
+	}
+	r.Steps[i].Status, r.Steps[i].Message = s, msg
+	return true
+}
+
+func (rt *Rotator) update(f func()) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	f()
+}
+
+func (rt *Rotator) prepare(ctx context.Context, r *Rotation, _ time.Duration) (string, error) {
+	states, err := rt.sc.GetAuthorityStates(ctx, r.Type)
+	if err != nil {
+		return "", err
+	}
+	// agents are listed before preparing so any SVID renewed later proves a sync after it
+	serials, err := rt.agentSerials(ctx)
+	if err != nil {
+		return "", err
+	}
+	prepared, msg := states.Prepared, ""
+	if prepared != nil {
+		// a rotation interrupted by a restart or a timeout left it, the agents are waited for again
+		rt.sc.Logger.Infof("Authority rotation %s resumes with prepared authority %s", r.ID, prepared.AuthorityID)
+		msg = fmt.Sprintf("resumed with prepared authority %s", prepared.AuthorityID)
+	} else {
+		if prepared, err = rt.sc.PrepareAuthority(ctx, r.Type); err != nil {
+			return "", err
+		}
+		msg = fmt.Sprintf("prepared authority %s", prepared.AuthorityID)
+	}
+	rt.update(func() {
+		if states.Active != nil {
+			r.OldAuthorityID = states.Active.AuthorityID
+		}
+		r.PreparedAuthorityID = prepared.AuthorityID
+		r.AgentsTotal = len(serials)
+		rt.serials = serials
+	})
+	return msg, nil
+}
+
+// agentSerials maps the SPIFFE ID of every agent that is not banned to its X509-SVID serial number
+func (rt *Rotator) agentSerials(ctx context.Context) (map[string]string, error) {
+	banned := false
+	agents, err := rt.sc.ListAgents(ctx, AgentFilter{Banned: &banned})
+	if err != nil {
+		return nil, err
+	}
+	serials := map[string]string{}
+	for _, a := range agents {
+		serials[SPIFFEIDString(a.Id)] = a.X509SvidSerialNumber
+	}
+	return serials, nil
+}
+
+// propagate waits until every agent has renewed its X509-SVID since the authority was
+// prepared. Agents fetch the bundle on each sync with the server, so an SVID issued after
+// the preparation means the agent holds the new bundle. Agents that are gone are not waited for.
+func (rt *Rotator) propagate(ctx context.Context, r *Rotation, poll time.Duration) (string, error) {
+	rt.mu.Lock()
+	before := rt.serials
+	rt.mu.Unlock()
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	var pending []string
+	for {
+		now, err := rt.agentSerials(ctx)
+		if err != nil {
+			if ctx.Err() != nil && pending != nil {
+				return "", rotationTimeoutError(pending)
+			}
+			return "", err
+		}
+		pending = []string{}
+		for id, serial := range before {
+			if cur, ok := now[id]; ok && cur == serial {
+				pending = append(pending, id)
+			}
+		}
+		rt.update(func() {
+			r.AgentsTotal = len(now)
+			r.AgentsSynced = len(now) - len(pending)
+			r.Steps[1].Message = fmt.Sprintf("%d of %d agents have the new bundle", r.AgentsSynced, r.AgentsTotal)
+		})
+		if len(pending) == 0 {
+			return fmt.Sprintf("all %d agents have the new bundle", len(now)), nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", rotationTimeoutError(pending)
+		case <-ticker.C:
+		}
+	}
+}
+
+func rotationTimeoutError(pending []string) error {
+	n := len(pending)
+	if n > rotationPendingAgentsShown {
+		pending = append(pending[:rotationPendingAgentsShown:rotationPendingAgentsShown], "...")
+	}
+	return fmt.Errorf("%d agents did not sync before the timeout: %s", n, strings.Join(pending, ", "))
+}
+
+func (rt *Rotator) activate(ctx context.Context, r *Rotation, _ time.Duration) (string, error) {
+	if _, err := rt.sc.ActivateAuthority(ctx, r.Type, r.PreparedAuthorityID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("activated authority %s", r.PreparedAuthorityID), nil
+}
+
+func (rt *Rotator) taint(ctx context.Context, r *Rotation, _ time.Duration) (string, error) {
+	if r.OldAuthorityID == "" {
+		return "no previous authority", errStepSkipped
+	}
+	if _, err := rt.sc.TaintAuthority(ctx, r.Type, r.OldAuthorityID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tainted authority %s", r.OldAuthorityID), nil
+}
@@ -0,0 +1,126 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	localauthoritypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/localauthority/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc"
+)
+
+// fakeAuthorities answers the X.509 local authority RPCs, unset RPCs panic
+type fakeAuthorities struct {
+	localauthoritypb.LocalAuthorityClient
+	mu    sync.Mutex
+	state *localauthoritypb.GetX509AuthorityStateResponse
+	calls []string
+}
+
+func (f *fakeAuthorities) GetX509AuthorityState(context.Context, *localauthoritypb.GetX509AuthorityStateRequest, ...grpc.CallOption) (*localauthoritypb.GetX509AuthorityStateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state, nil
+}
+
+func (f *fakeAuthorities) PrepareX509Authority(context.Context, *localauthoritypb.PrepareX509AuthorityRequest, ...grpc.CallOption) (*localauthoritypb.PrepareX509AuthorityResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "prepare new")
+	return &localauthoritypb.PrepareX509AuthorityResponse{PreparedAuthority: &localauthoritypb.AuthorityState{AuthorityId: "new"}}, nil
+}
+
+func (f *fakeAuthorities) ActivateX509Authority(_ context.Context, req *localauthoritypb.ActivateX509AuthorityRequest, _ ...grpc.CallOption) (*localauthoritypb.ActivateX509AuthorityResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "activate "+req.AuthorityId)
+	return &localauthoritypb.ActivateX509AuthorityResponse{ActivatedAuthority: &localauthoritypb.AuthorityState{AuthorityId: req.AuthorityId}}, nil
+}
+
+func (f *fakeAuthorities) TaintX509Authority(_ context.Context, req *localauthoritypb.TaintX509AuthorityRequest, _ ...grpc.CallOption) (*localauthoritypb.TaintX509AuthorityResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, "taint "+req.AuthorityId)
+	return &localauthoritypb.TaintX509AuthorityResponse{TaintedAuthority: &localauthoritypb.AuthorityState{AuthorityId: req.AuthorityId}}, nil
+}
+
+// fakeAgents lists one agent whose SVID is renewed after the first listing
+type fakeAgents struct {
+	agentpb.AgentClient
+	mu    sync.Mutex
+	lists int
+}
+
+func (f *fakeAgents) ListAgents(context.Context, *agentpb.ListAgentsRequest, ...grpc.CallOption) (*agentpb.ListAgentsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lists++
+	serial := "1"
+	if f.lists > 1 {
+		serial = "2"
+	}
+	return &agentpb.ListAgentsResponse{Agents: []*types.Agent{{
+		Id:                   &types.SPIFFEID{TrustDomain: "example.org", Path: "/spire/agent/k8s_psat/ambient-a/node-1"},
+		X509SvidSerialNumber: serial,
+	}}}, nil
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     *localauthoritypb.GetX509AuthorityStateResponse
+		message   string
+		wantCalls []string
+	}{
+		{
+			name:      "prepares a new authority",
+			state:     &localauthoritypb.GetX509AuthorityStateResponse{Active: &localauthoritypb.AuthorityState{AuthorityId: "old"}},
+			message:   "prepared authority new",
+			wantCalls: []string{"prepare new", "activate new", "taint old"},
+		},
+		{
+			name: "resumes with the prepared authority",
+			state: &localauthoritypb.GetX509AuthorityStateResponse{
+				Active:   &localauthoritypb.AuthorityState{AuthorityId: "old"},
+				Prepared: &localauthoritypb.AuthorityState{AuthorityId: "left"},
+			},
+			message:   "resumed with prepared authority left",
+			wantCalls: []string{"activate left", "taint old"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			authorities := &fakeAuthorities{state: tt.state}
+			sc := &SPIREClient{Logger: testLogger(&out), LocalAuthority: authorities, Agents: &fakeAgents{}}
+			rt := NewRotator(sc)
+			if _, err := rt.Start(RotationOptions{Type: AuthorityX509, PollInterval: 1, Timeout: 10}, nil); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			rt.wg.Wait()
+
+			r := rt.Current()
+			if r.Status != RotationSucceeded {
+				t.Fatalf("status = %s, want %s: %+v", r.Status, RotationSucceeded, r.Steps)
+			}
+			if r.Steps[0].Message != tt.message {
+				t.Errorf("prepare message = %q, want %q", r.Steps[0].Message, tt.message)
+			}
+			if len(authorities.calls) != len(tt.wantCalls) {
+				t.Fatalf("calls = %v, want %v", authorities.calls, tt.wantCalls)
+			}
+			for i := range tt.wantCalls {
+				if authorities.calls[i] != tt.wantCalls[i] {
+					t.Errorf("calls = %v, want %v", authorities.calls, tt.wantCalls)
+					break
+				}
+			}
+			if r.AgentsSynced != 1 || r.AgentsTotal != 1 {
+				t.Errorf("agents synced %d of %d, want 1 of 1", r.AgentsSynced, r.AgentsTotal)
+			}
+		})
+	}
+}
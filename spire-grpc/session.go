@@ -2,19 +2,24 @@ package spire_grpc
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"os"
+	"spire-api/redact"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spiffe/go-spiffe/v2/spiffegrpc/grpccredentials"
 	"github.com/spiffe/go-spiffe/v2/spiffeid"
 	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	bundlepb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/bundle/v1"
+	debugpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/debug/v1"
 	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	localauthoritypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/localauthority/v1"
+	svidpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/svid/v1"
+	trustdomainpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/trustdomain/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 const (
@@ -24,11 +29,21 @@ const (
 )
 
 // NewSpireClient Code taken from https://github.com/spiffe/go-spiffe/blob/main/examples/spiffe-grpc/client/main.go
-func NewSpireClient(spireServer string, trustDomain string, uds string) (*SPIREClient, error) {
+// Several spireServers are replicas of one HA deployment, requests are balanced across the healthy ones.
+// ctx bounds the wait for the first SVID, the source keeps watching until Close.
+func NewSpireClient(ctx context.Context, spireServers []string, trustDomain string, uds string, t Timeouts, logger *logrus.Logger) (*SPIREClient, error) {
 	// Create a new SPIRE client using the SPIFFE Workload API
-	logger := logrus.New()
+	logger = orDefault(logger)
+	td, err := spiffeid.TrustDomainFromString(trustDomain)
+	if err != nil {
+		logger.Errorf("Invalid trust domain %q: %v", trustDomain, err)
+		return nil, err
+	}
+	serverID, err := spiffeid.FromPath(td, "/spire/server")
+	if err != nil {
+		return nil, err
+	}
 	logger.Info("Creating new spire source...")
-	ctx := context.Background()
 	source, err := workloadapi.NewX509Source(ctx,
 		workloadapi.WithClientOptions(workloadapi.WithAddr(fmt.Sprintf("unix://%s", uds))))
 	if err != nil {
@@ -38,69 +53,102 @@ func NewSpireClient(spireServer string, trustDomain string, uds string) (*SPIREC
 
 	logger.Info("Creating new connection...")
 	// MTLS connection to SPIRE server
-	serverID := spiffeid.RequireFromString(fmt.Sprintf("spiffe://%s/spire/server", trustDomain))
-	conn, err := grpc.NewClient(spireServer, grpc.WithTransportCredentials(
-		grpccredentials.MTLSClientCredentials(source, source, tlsconfig.AuthorizeID(serverID))))
+	creds := grpc.WithTransportCredentials(
+		grpccredentials.MTLSClientCredentials(source, source, tlsconfig.AuthorizeID(serverID)))
+	target, opts := dialTarget(spireServers)
+	conn, err := grpc.NewClient(target, append(append(opts, dialOptions(t, logger)...), creds)...)
 	if err != nil {
 		logger.Errorf("Failed to create gRPC connection: %v", err)
+		source.Close()
 		return nil, err
 	}
-
-	sc := &SPIREClient{
-		Logger:   logrus.New(),
-		GRPCConn: conn,
-		Client:   entrypb.NewEntryClient(conn),
+	var pinned *grpc.ClientConn
+	if len(spireServers) > 1 {
+		target, opts := pinnedDialTarget(spireServers)
+		pinned, err = grpc.NewClient(target, append(append(opts, dialOptions(t, logger)...), creds)...)
+		if err != nil {
+			logger.Errorf("Failed to create gRPC connection: %v", err)
+			conn.Close()
+			source.Close()
+			return nil, err
+		}
 	}
 
+	sc := newSPIREClient(conn, pinned, trustDomain, spireServers[0], logger)
+	sc.X509Source = source
+
 	return sc, nil
 }
 
 // NewClient creates a new SPIRE client using TLS with certs
-// This is an alternative to using the Workload API
-// It is less secure and not recommended for production use
-// It is useful for testing and development
-func NewClient(spireServer string) (*SPIREClient, error) {
+// This is an alternative to using the Workload API, useful for testing and development.
+// The server must present the SVID spiffe://<trustDomain>/spire/server signed by the CA file,
+// and the files are reloaded when they change on disk.
+func NewClient(spireServer string, trustDomain string, files CertFiles, t Timeouts, logger *logrus.Logger) (*SPIREClient, error) {
 	// Create a new SPIRE client using cert and key files
-	logger := logrus.New()
+	logger = orDefault(logger)
 
-	caCert, err := os.ReadFile(CA)
+	td, err := spiffeid.TrustDomainFromString(trustDomain)
 	if err != nil {
-		logger.Errorf("Failed to read CA file: %v", err)
+		logger.Errorf("Invalid trust domain %q: %v", trustDomain, err)
 		return nil, err
 	}
-
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(caCert) {
-		logger.Errorf("Failed to append CA file")
+	serverID, err := spiffeid.FromPath(td, "/spire/server")
+	if err != nil {
 		return nil, err
 	}
-	clientCert, err := tls.LoadX509KeyPair(CERT, KEY)
+	certs, err := newCertReloader(files.withDefaults(), serverID, logger)
 	if err != nil {
-		logger.Errorf("Failed to load client cert: %v", err)
+		logger.Errorf("Failed to load static certificates: %v", err)
 		return nil, err
 	}
-	tlsConfig := &tls.Config{
-		Certificates:       []tls.Certificate{clientCert},
-		RootCAs:            certPool,
-		InsecureSkipVerify: true,
-	}
-	grpcCreds := credentials.NewTLS(tlsConfig)
+	grpcCreds := credentials.NewTLS(certs.tlsConfig())
 
 	logger.Infof("Creating connection to SPIRE server: %v", spireServer)
 
-	conn, err := grpc.NewClient(spireServer, grpc.WithTransportCredentials(grpcCreds))
+	conn, err := grpc.NewClient(spireServer, append(dialOptions(t, logger), grpc.WithTransportCredentials(grpcCreds))...)
 
 	if err != nil {
 		logger.Errorf("Failed to create connection to SPIRE server: %v", err)
+		certs.Close()
 		return nil, err
 	}
 
 	logger.Info("Connection created to SPIRE server")
 
-	sc := &SPIREClient{
-		Logger:   logrus.New(),
-		GRPCConn: conn,
-		Client:   entrypb.NewEntryClient(conn),
-	}
+	sc := newSPIREClient(conn, nil, trustDomain, spireServer, logger)
+	sc.certs = certs
 	return sc, nil
 }
+
+// orDefault returns logger, or a new one for callers that do not inject a logger
+func orDefault(logger *logrus.Logger) *logrus.Logger {
+	if logger == nil {
+		return redact.NewLogger()
+	}
+	return logger
+}
+
+// newSPIREClient creates the clients of every SPIRE server API on conn. The per-server APIs use
+// pinned when it is set, see pinnedDialTarget.
+func newSPIREClient(conn *grpc.ClientConn, pinned *grpc.ClientConn, trustDomain string, serverAddress string, logger *logrus.Logger) *SPIREClient {
+	server := conn
+	if pinned != nil {
+		server = pinned
+	}
+	return &SPIREClient{
+		Logger:         logger,
+		GRPCConn:       conn,
+		Client:         entrypb.NewEntryClient(conn),
+		Agents:         agentpb.NewAgentClient(conn),
+		Bundles:        bundlepb.NewBundleClient(conn),
+		TrustDomains:   trustdomainpb.NewTrustDomainClient(conn),
+		SVIDs:          svidpb.NewSVIDClient(conn),
+		Debug:          debugpb.NewDebugClient(server),
+		LocalAuthority: localauthoritypb.NewLocalAuthorityClient(server),
+		Health:         healthpb.NewHealthClient(server),
+		TrustDomain:    trustDomain,
+		ServerAddress:  serverAddress,
+		pinnedConn:     pinned,
+	}
+}
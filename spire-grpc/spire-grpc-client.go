@@ -4,9 +4,12 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"spire-api/apierror"
 
 	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
 	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -29,29 +32,27 @@ type entryID string
 //TODO: Update server.conf with the kubeconfig
 //TODO: Watch for cert file updates and reload the server.conf
 
-func (sc *SPIREClient) GetEntries() ([]*types.Entry, error) {
-	resp, err := (sc.Client).ListEntries(context.Background(), &entrypb.ListEntriesRequest{})
+func (sc *SPIREClient) GetEntries(ctx context.Context) ([]*types.Entry, error) {
+	resp, err := (sc.Client).ListEntries(ctx, &entrypb.ListEntriesRequest{})
 	if err != nil {
-		sc.Logger.Errorf("Failed to list entries: %v", err)
-		return nil, fmt.Errorf(err.Error())
-	}
-	for _, entry := range resp.Entries {
-		sc.Logger.Infof("Entry: %v", entry)
+		sc.log(ctx).Errorf("Failed to list entries: %v", err)
+		return nil, err
 	}
+	sc.log(ctx).Debugf("Listed %d entries", len(resp.Entries))
 	return resp.Entries, nil
 }
 
-func (sc *SPIREClient) GetEntryByID(id string) {
-	resp, err := (sc.Client).GetEntry(context.Background(), &entrypb.GetEntryRequest{Id: id})
+func (sc *SPIREClient) GetEntryByID(ctx context.Context, id string) {
+	resp, err := (sc.Client).GetEntry(ctx, &entrypb.GetEntryRequest{Id: id})
 	if err != nil {
-		sc.Logger.Errorf("Failed to get entry: %v", err)
+		sc.log(ctx).Errorf("Failed to get entry: %v", err)
 		return
 	}
-	sc.Logger.Infof("Entry: %v", resp.SpiffeId)
+	sc.log(ctx).Infof("Entry: %v", resp.SpiffeId)
 }
 
-func (sc *SPIREClient) GetEntryBySPIFFE(e *Entry) ([]*types.Entry, error) {
-	sc.Logger.Infof("fetching entry by spiffeID")
+func (sc *SPIREClient) GetEntryBySPIFFE(ctx context.Context, e *Entry) ([]*types.Entry, error) {
+	sc.log(ctx).Infof("fetching entry by spiffeID")
 	spiffeID := &types.SPIFFEID{
 		TrustDomain: e.TrustDomain,
 		Path:        fmt.Sprintf("/ns/%s/sa/%s", e.Namespace, e.ServiceAccount),
@@ -61,17 +62,17 @@ func (sc *SPIREClient) GetEntryBySPIFFE(e *Entry) ([]*types.Entry, error) {
 			BySpiffeId: spiffeID,
 		},
 	}
-	resp, err := (sc.Client).ListEntries(context.Background(), req)
+	resp, err := (sc.Client).ListEntries(ctx, req)
 	if err != nil {
-		sc.Logger.Errorf("Error listing entry by spiffeid %s", err.Error())
+		sc.log(ctx).Errorf("Error listing entry by spiffeid %s", err.Error())
 		return nil, err
 	}
-	sc.Logger.Infof("%s", resp.Entries)
+	sc.log(ctx).Debugf("Found %d entries for spiffeID %s", len(resp.Entries), spiffeID.Path)
 	return resp.Entries, nil
 }
 
-func (sc *SPIREClient) CreateEntry(e *Entry) (*entryID, error) {
-	sc.Logger.Infof("Creating entry")
+func (sc *SPIREClient) CreateEntry(ctx context.Context, e *Entry) (*entryID, error) {
+	sc.log(ctx).Infof("Creating entry")
 	var sel []*types.Selector
 
 	//default parentPath
@@ -120,64 +121,99 @@ func (sc *SPIREClient) CreateEntry(e *Entry) (*entryID, error) {
 					TrustDomain: e.TrustDomain,
 					Path:        fmt.Sprintf("/ns/%s/sa/%s", e.Namespace, e.ServiceAccount),
 				},
-				Selectors: sel,
+				Selectors:     sel,
+				Admin:         e.Admin,
+				Downstream:    e.Downstream,
+				FederatesWith: e.FederatesWith,
 			},
 		},
 	}
 
-	resp, err := (sc.Client).BatchCreateEntry(context.Background(), entry)
+	resp, err := (sc.Client).BatchCreateEntry(ctx, entry)
 	if err != nil {
-		sc.Logger.Errorf("Failed to create entry: %v", err)
+		sc.log(ctx).Errorf("Failed to create entry: %v", err)
+		return nil, err
+	}
+	// an existing entry is reported as AlreadyExists in the result, not as an RPC error
+	if err := statusError(resp.Results[0].Status); err != nil {
+		sc.log(ctx).Errorf("Failed to create entry: %v", err)
 		return nil, err
 	}
 
 	eID := entryID(resp.Results[0].Entry.Id)
-	sc.Logger.Infof("EntryID: %v", eID)
+	sc.log(ctx).Infof("EntryID: %v", eID)
 
 	return &eID, nil
 }
 
-func (sc *SPIREClient) DeleteEntryBySPIFFE(e *Entry) error {
-	sc.Logger.Infof("Fetching entry by spiffeID first")
-	resp, err := sc.GetEntryBySPIFFE(e)
+// DeleteEntryBySPIFFE deletes every entry with the entry's SPIFFE ID and returns the deleted entry IDs
+func (sc *SPIREClient) DeleteEntryBySPIFFE(ctx context.Context, e *Entry) ([]string, error) {
+	sc.log(ctx).Infof("Fetching entry by spiffeID first")
+	resp, err := sc.GetEntryBySPIFFE(ctx, e)
 	if err != nil {
-		sc.Logger.Errorf("Failed to get entry by spiffeID: %v, may be deleted. ignoring", err)
-		return nil
+		// an entry that does not exist is an empty list, an error means nothing is known about it
+		sc.log(ctx).Errorf("Failed to get entry by spiffeID, nothing deleted: %v", err)
+		ae := apierror.From(err)
+		return nil, &apierror.Error{Code: ae.Code, Message: "failed to look up the entries to delete: " + ae.Message, Details: ae.Details, Err: err}
 	}
 	var entryIDs []string
 	for _, entry := range resp {
 		entryIDs = append(entryIDs, entry.Id)
 	}
-	sc.Logger.Infof("Deleting entry by spiffeID")
+	sc.log(ctx).Infof("Deleting entry by spiffeID")
+	return sc.DeleteEntries(ctx, entryIDs)
+}
 
-	delresp, err := (sc.Client).BatchDeleteEntry(context.Background(), &entrypb.BatchDeleteEntryRequest{
-		Ids: entryIDs,
+// DeleteEntries deletes the entries by ID and returns the IDs the SPIRE server deleted. Entries it
+// rejects, e.g. as NotFound or PermissionDenied, are left out and the first rejection is returned.
+func (sc *SPIREClient) DeleteEntries(ctx context.Context, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	resp, err := (sc.Client).BatchDeleteEntry(ctx, &entrypb.BatchDeleteEntryRequest{
+		Ids: ids,
 	})
 	if err != nil {
-		sc.Logger.Errorf("Failed to delete entry: %v", err)
-		return err
+		sc.log(ctx).Errorf("Failed to delete entry: %v", err)
+		return nil, err
 	}
-	sc.Logger.Infof("Entry: %v", delresp.Results)
-	return nil
+	var deleted []string
+	var failed error
+	for _, r := range resp.Results {
+		if err := statusError(r.Status); err != nil {
+			sc.log(ctx).Errorf("Failed to delete entry %s: %v", r.Id, err)
+			if failed == nil {
+				failed = fmt.Errorf("failed to delete entry %s: %w", r.Id, err)
+			}
+			continue
+		}
+		deleted = append(deleted, r.Id)
+	}
+	sc.log(ctx).Infof("Deleted %d of %d entries", len(deleted), len(ids))
+	return deleted, failed
 }
 
-func (sc *SPIREClient) RegisterKubeConfig(e *Entry) error {
+func (sc *SPIREClient) RegisterKubeConfig(ctx context.Context, e *Entry) error {
 	// Placeholder for registering kubeconfig, if needed
 	if e.KubeConfig == "" {
-		sc.Logger.Infof("No kubeconfig provided for entry, skipping registration")
+		sc.log(ctx).Infof("No kubeconfig provided for entry, skipping registration")
 		return nil
 	}
-	kcBytes := e.KubeConfig
-	kcDecoded, err := base64.StdEncoding.DecodeString(kcBytes)
-	if err != nil {
-		sc.Logger.Errorf("Failed to decode kubeconfig: %v", err)
+	if _, err := base64.StdEncoding.DecodeString(e.KubeConfig); err != nil {
+		sc.log(ctx).Errorf("Failed to decode kubeconfig: %v", err)
 		return err
 	}
-	//DEBUG
-	sc.Logger.Infof("Registering kubeconfig for entry: %v", kcDecoded)
-
-	sc.Logger.Infof("Registering kubeconfig for entry: %v", e)
+	// Never log the decoded kubeconfig, it holds cluster credentials
+	sc.log(ctx).Infof("Registering kubeconfig for entry: %v", e)
 	// In a real implementation, you would call the appropriate SPIRE API to register the kubeconfig
 	// For now, just return nil to indicate success
 	return nil
 }
+
+// statusError converts the per-item status of a batch RPC to a gRPC error, nil when OK
+func statusError(s *types.Status) error {
+	if s == nil || codes.Code(s.Code) == codes.OK {
+		return nil
+	}
+	return status.Error(codes.Code(s.Code), s.Message)
+}
@@ -0,0 +1,194 @@
+package spire_grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"spire-api/apierror"
+	"spire-api/redact"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeEntries answers the entry RPCs of the tests, unset RPCs panic
+type fakeEntries struct {
+	entrypb.EntryClient
+	created *entrypb.BatchCreateEntryResponse
+	listed  []*types.Entry
+	listErr error
+	deleted *entrypb.BatchDeleteEntryResponse
+}
+
+func (f *fakeEntries) BatchCreateEntry(_ context.Context, req *entrypb.BatchCreateEntryRequest, _ ...grpc.CallOption) (*entrypb.BatchCreateEntryResponse, error) {
+	return f.created, nil
+}
+
+func (f *fakeEntries) ListEntries(context.Context, *entrypb.ListEntriesRequest, ...grpc.CallOption) (*entrypb.ListEntriesResponse, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	return &entrypb.ListEntriesResponse{Entries: f.listed}, nil
+}
+
+func (f *fakeEntries) BatchDeleteEntry(context.Context, *entrypb.BatchDeleteEntryRequest, ...grpc.CallOption) (*entrypb.BatchDeleteEntryResponse, error) {
+	return f.deleted, nil
+}
+
+func createdEntry(id string) *entrypb.BatchCreateEntryResponse {
+	return &entrypb.BatchCreateEntryResponse{Results: []*entrypb.BatchCreateEntryResponse_Result{{
+		Status: &types.Status{},
+		Entry:  &types.Entry{Id: id},
+	}}}
+}
+
+// testLogger logs everything as JSON into out, through the redaction hook like logging.New
+func testLogger(out *bytes.Buffer) *logrus.Logger {
+	logger := redact.NewLogger()
+	logger.SetOutput(out)
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	logger.SetLevel(logrus.TraceLevel)
+	return logger
+}
+
+const kubeconfigToken = "kubeconfig-token-secret"
+
+func TestCreateEntryLogsNoKubeconfig(t *testing.T) {
+	var out bytes.Buffer
+	sc := &SPIREClient{Logger: testLogger(&out), Client: &fakeEntries{created: createdEntry("entry-1")}}
+	kc := "apiVersion: v1\nkind: Config\nusers:\n- name: spire\n  user:\n    token: " + kubeconfigToken + "\n"
+	e := &Entry{
+		TrustDomain:    "example.org",
+		Cluster:        "ambient-a",
+		Namespace:      "spire",
+		ServiceAccount: "spire-agent",
+		KubeConfig:     base64.StdEncoding.EncodeToString([]byte(kc)),
+	}
+
+	ctx := context.Background()
+	if _, err := sc.CreateEntry(ctx, e); err != nil {
+		t.Fatalf("CreateEntry: %v", err)
+	}
+	if err := sc.RegisterKubeConfig(ctx, e); err != nil {
+		t.Fatalf("RegisterKubeConfig: %v", err)
+	}
+	// the ways handlers log entries and kubeconfigs
+	sc.log(ctx).Infof("Creating entry %v", e)
+	sc.log(ctx).WithField("entry", e).Info("Creating entry")
+	sc.log(ctx).WithField("kubeconfig", kc).Debugf("Writing kubeconfig %s", kc)
+
+	if !strings.Contains(out.String(), "entry-1") {
+		t.Fatalf("CreateEntry logged nothing: %s", out.String())
+	}
+	for _, secret := range []string{kubeconfigToken, e.KubeConfig, e.KubeConfig[:64]} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("log output holds kubeconfig material %q:\n%s", secret, out.String())
+		}
+	}
+}
+
+func TestCreateEntryStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status *types.Status
+		code   apierror.Code
+	}{
+		{name: "created", status: &types.Status{}},
+		{name: "already exists", status: &types.Status{Code: int32(codes.AlreadyExists), Message: "similar entry already exists"}, code: apierror.Conflict},
+		{name: "invalid", status: &types.Status{Code: int32(codes.InvalidArgument), Message: "invalid selector"}, code: apierror.Validation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			// a failed result carries no entry
+			resp := &entrypb.BatchCreateEntryResponse{Results: []*entrypb.BatchCreateEntryResponse_Result{{Status: tt.status}}}
+			if tt.code == "" {
+				resp = createdEntry("entry-1")
+			}
+			sc := &SPIREClient{Logger: testLogger(&out), Client: &fakeEntries{created: resp}}
+			id, err := sc.CreateEntry(context.Background(), &Entry{TrustDomain: "example.org", Cluster: "ambient-a", Namespace: "apps", ServiceAccount: "web"})
+			if tt.code == "" {
+				if err != nil || id == nil || *id != "entry-1" {
+					t.Errorf("CreateEntry = %v, %v, want entry-1", id, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("CreateEntry succeeded, want %s", tt.code)
+			}
+			if got := apierror.From(err).Code; got != tt.code {
+				t.Errorf("error code = %s, want %s (%v)", got, tt.code, err)
+			}
+		})
+	}
+}
+
+func TestDeleteEntryStatus(t *testing.T) {
+	deleted := func(id string, code codes.Code) *entrypb.BatchDeleteEntryResponse_Result {
+		return &entrypb.BatchDeleteEntryResponse_Result{Id: id, Status: &types.Status{Code: int32(code)}}
+	}
+	tests := []struct {
+		name    string
+		results []*entrypb.BatchDeleteEntryResponse_Result
+		deleted []string
+		code    apierror.Code
+	}{
+		{name: "deleted", results: []*entrypb.BatchDeleteEntryResponse_Result{deleted("a", codes.OK), deleted("b", codes.OK)}, deleted: []string{"a", "b"}},
+		{name: "not found", results: []*entrypb.BatchDeleteEntryResponse_Result{deleted("a", codes.OK), deleted("b", codes.NotFound)}, deleted: []string{"a"}, code: apierror.NotFound},
+		{name: "permission denied", results: []*entrypb.BatchDeleteEntryResponse_Result{deleted("a", codes.PermissionDenied), deleted("b", codes.OK)}, deleted: []string{"b"}, code: apierror.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			entries := &fakeEntries{
+				listed:  []*types.Entry{{Id: "a"}, {Id: "b"}},
+				deleted: &entrypb.BatchDeleteEntryResponse{Results: tt.results},
+			}
+			sc := &SPIREClient{Logger: testLogger(&out), Client: entries}
+			ids, err := sc.DeleteEntryBySPIFFE(context.Background(), &Entry{TrustDomain: "example.org", Namespace: "apps", ServiceAccount: "web"})
+			if strings.Join(ids, ",") != strings.Join(tt.deleted, ",") {
+				t.Errorf("deleted IDs = %v, want %v", ids, tt.deleted)
+			}
+			if tt.code == "" {
+				if err != nil {
+					t.Errorf("DeleteEntryBySPIFFE: %v", err)
+				}
+				return
+			}
+			if got := apierror.From(err).Code; err == nil || got != tt.code {
+				t.Errorf("error = %v with code %s, want %s", err, got, tt.code)
+			}
+		})
+	}
+}
+
+func TestDeleteEntryLookupStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code apierror.Code
+	}{
+		{name: "unavailable", err: status.Error(codes.Unavailable, "connection refused"), code: apierror.UpstreamUnavailable},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "not an admin"), code: apierror.PermissionDenied},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "bad filter"), code: apierror.Validation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			sc := &SPIREClient{Logger: testLogger(&out), Client: &fakeEntries{listErr: tt.err}}
+			ids, err := sc.DeleteEntryBySPIFFE(context.Background(), &Entry{TrustDomain: "example.org", Namespace: "apps", ServiceAccount: "web"})
+			if ids != nil || err == nil {
+				t.Fatalf("DeleteEntryBySPIFFE = %v, %v, want an error", ids, err)
+			}
+			if e := apierror.From(err); e.Code != tt.code || !strings.HasPrefix(e.Message, "failed to look up the entries to delete: ") {
+				t.Errorf("error = %s %q, want %s", e.Code, e.Message, tt.code)
+			}
+		})
+	}
+}
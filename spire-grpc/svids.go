@@ -0,0 +1,144 @@
+package spire_grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	svidpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/svid/v1"
+	"github.com/spiffe/spire-api-sdk/proto/spire/api/types"
+)
+
+// TTLs in seconds for minted SVIDs. Requests above the maximum are rejected, not clamped.
+const (
+	DefaultX509SVIDTTL = 3600
+	MaxX509SVIDTTL     = 86400
+	DefaultJWTSVIDTTL  = 300
+	MaxJWTSVIDTTL      = 3600
+)
+
+// X509SVIDRequest carries a PEM CSR with the SPIFFE ID of the SVID as its only URI SAN
+type X509SVIDRequest struct {
+	CSR string `json:"csr" binding:"required"`
+	TTL int32  `json:"ttl,omitempty" binding:"gte=0"`
+}
+
+type JWTSVIDRequest struct {
+	SpiffeID string   `json:"spiffeId" binding:"required"`
+	Audience []string `json:"audience" binding:"required,min=1,dive,required"`
+	TTL      int32    `json:"ttl,omitempty" binding:"gte=0"`
+}
+
+type X509SVIDResult struct {
+	SpiffeID string `json:"spiffeId"`
+	// CertChain is the PEM chain, leaf first
+	CertChain string `json:"certChain"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+type JWTSVIDResult struct {
+	SpiffeID  string `json:"spiffeId"`
+	Token     string `json:"token"`
+	IssuedAt  int64  `json:"issuedAt"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+func validateTTL(ve *ValidationError, ttl int32, max int32) {
+	if ttl > max {
+		ve.add("ttl", fmt.Sprintf("must be at most %d seconds", max))
+	}
+}
+
+// ParseX509SVIDRequest checks the CSR signature and that it asks for one SPIFFE ID in this
+// server's trust domain, and returns the DER CSR and that ID.
+func (sc *SPIREClient) ParseX509SVIDRequest(r *X509SVIDRequest) ([]byte, spiffeid.ID, error) {
+	ve := &ValidationError{}
+	validateTTL(ve, r.TTL, MaxX509SVIDTTL)
+	var id spiffeid.ID
+	block, _ := pem.Decode([]byte(r.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		ve.add("csr", "must be a PEM CERTIFICATE REQUEST")
+		return nil, id, ve
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		ve.add("csr", err.Error())
+		return nil, id, ve
+	}
+	if err := csr.CheckSignature(); err != nil {
+		ve.add("csr", fmt.Sprintf("invalid signature: %v", err))
+	}
+	if len(csr.URIs) != 1 {
+		ve.add("csr", "must have exactly one URI SAN")
+	} else if id, err = spiffeid.FromURI(csr.URIs[0]); err != nil {
+		ve.add("csr", err.Error())
+	} else if id.TrustDomain().Name() != sc.TrustDomain {
+		ve.add("csr", fmt.Sprintf("SPIFFE ID must be in trust domain %q", sc.TrustDomain))
+	}
+	if len(ve.Fields) > 0 {
+		return nil, id, ve
+	}
+	return block.Bytes, id, nil
+}
+
+// ParseJWTSVIDRequest checks the TTL and that the SPIFFE ID is in this server's trust domain
+func (sc *SPIREClient) ParseJWTSVIDRequest(r *JWTSVIDRequest) (spiffeid.ID, error) {
+	ve := &ValidationError{}
+	validateTTL(ve, r.TTL, MaxJWTSVIDTTL)
+	id, err := spiffeid.FromString(r.SpiffeID)
+	if err != nil {
+		ve.add("spiffeId", err.Error())
+	} else if id.TrustDomain().Name() != sc.TrustDomain {
+		ve.add("spiffeId", fmt.Sprintf("must be in trust domain %q", sc.TrustDomain))
+	}
+	if len(ve.Fields) > 0 {
+		return id, ve
+	}
+	return id, nil
+}
+
+// MintX509SVID signs the DER CSR. A ttl of 0 uses DefaultX509SVIDTTL.
+func (sc *SPIREClient) MintX509SVID(ctx context.Context, csr []byte, ttl int32) (*X509SVIDResult, error) {
+	if ttl <= 0 {
+		ttl = DefaultX509SVIDTTL
+	}
+	resp, err := sc.SVIDs.MintX509SVID(ctx, &svidpb.MintX509SVIDRequest{Csr: csr, Ttl: ttl})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to mint X509-SVID: %v", err)
+		return nil, err
+	}
+	res := &X509SVIDResult{
+		SpiffeID:  SPIFFEIDString(resp.Svid.Id),
+		ExpiresAt: resp.Svid.ExpiresAt,
+	}
+	for _, der := range resp.Svid.CertChain {
+		res.CertChain += string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+	sc.log(ctx).Infof("Minted X509-SVID for %s with ttl %ds", res.SpiffeID, ttl)
+	return res, nil
+}
+
+// MintJWTSVID mints a JWT-SVID for id. A ttl of 0 uses DefaultJWTSVIDTTL.
+func (sc *SPIREClient) MintJWTSVID(ctx context.Context, id spiffeid.ID, audience []string, ttl int32) (*JWTSVIDResult, error) {
+	if ttl <= 0 {
+		ttl = DefaultJWTSVIDTTL
+	}
+	resp, err := sc.SVIDs.MintJWTSVID(ctx, &svidpb.MintJWTSVIDRequest{
+		Id:       &types.SPIFFEID{TrustDomain: id.TrustDomain().Name(), Path: id.Path()},
+		Audience: audience,
+		Ttl:      ttl,
+	})
+	if err != nil {
+		sc.log(ctx).Errorf("Failed to mint JWT-SVID for %s: %v", id, err)
+		return nil, err
+	}
+	sc.log(ctx).Infof("Minted JWT-SVID for %s with ttl %ds", id, ttl)
+	return &JWTSVIDResult{
+		SpiffeID:  SPIFFEIDString(resp.Svid.Id),
+		Token:     resp.Svid.Token,
+		IssuedAt:  resp.Svid.IssuedAt,
+		ExpiresAt: resp.Svid.ExpiresAt,
+	}, nil
+}
@@ -1,24 +1,106 @@
 package spire_grpc
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
+	"spire-api/logging"
+	"spire-api/redact"
+	"text/template"
+
 	"github.com/sirupsen/logrus"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+	agentpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/agent/v1"
+	bundlepb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/bundle/v1"
+	debugpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/debug/v1"
 	entrypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/entry/v1"
+	localauthoritypb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/localauthority/v1"
+	svidpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/svid/v1"
+	trustdomainpb "github.com/spiffe/spire-api-sdk/proto/spire/api/server/trustdomain/v1"
 	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Entry struct {
-	TrustDomain    string `json:"trustDomain" required:"true"`
-	ServiceAccount string `json:"serviceAccount" required:"true"`
-	Namespace      string `json:"namespace" required:"true"`
-	Cluster        string `json:"cluster" required:"true"`
+	TrustDomain    string `json:"trustDomain" binding:"required"`
+	ServiceAccount string `json:"serviceAccount" binding:"required"`
+	Namespace      string `json:"namespace" binding:"required"`
+	Cluster        string `json:"cluster" binding:"required"`
 	KubeConfig     string `json:"kubeConfig,omitempty"`
-	SpireDir       string `json:"spireDir,omitempty"`
+	Admin          bool   `json:"admin,omitempty"`
+	Downstream     bool   `json:"downstream,omitempty"`
+	// FederatesWith lists foreign trust domains whose bundles are sent to the workload
+	FederatesWith []string `json:"federatesWith,omitempty"`
+	// EvictAgents evicts the cluster's agents when its agent entry is deleted
+	EvictAgents bool `json:"evictAgents,omitempty"`
+	// SpireDir is set from the server configuration, never from the request
+	SpireDir string `json:"-"`
+}
+
+// Redacted returns a copy of the entry with the kubeconfig masked
+func (e Entry) Redacted() Entry {
+	if e.KubeConfig != "" {
+		e.KubeConfig = redact.Mask
+	}
+	return e
+}
+
+// String masks the kubeconfig so an Entry can be logged with %v
+func (e Entry) String() string {
+	r := e.Redacted()
+	return fmt.Sprintf("{TrustDomain:%s ServiceAccount:%s Namespace:%s Cluster:%s KubeConfig:%s Admin:%t Downstream:%t FederatesWith:%v EvictAgents:%t SpireDir:%s}",
+		r.TrustDomain, r.ServiceAccount, r.Namespace, r.Cluster, r.KubeConfig, r.Admin, r.Downstream, r.FederatesWith, r.EvictAgents, r.SpireDir)
+}
+
+// LogValue implements slog.LogValuer with the kubeconfig masked
+func (e Entry) LogValue() slog.Value {
+	r := e.Redacted()
+	return slog.GroupValue(
+		slog.String("trustDomain", r.TrustDomain),
+		slog.String("serviceAccount", r.ServiceAccount),
+		slog.String("namespace", r.Namespace),
+		slog.String("cluster", r.Cluster),
+		slog.String("kubeConfig", r.KubeConfig),
+		slog.Bool("admin", r.Admin),
+		slog.Bool("downstream", r.Downstream),
+		slog.Any("federatesWith", r.FederatesWith),
+		slog.Bool("evictAgents", r.EvictAgents),
+	)
 }
 
 type SPIREClient struct {
+	// Name is the backend of the client, it labels its metrics
+	Name     string
 	Logger   *logrus.Logger
 	GRPCConn *grpc.ClientConn
 	Client   entrypb.EntryClient
+	Agents   agentpb.AgentClient
+	Bundles  bundlepb.BundleClient
+	// TrustDomains manages federation relationships
+	TrustDomains   trustdomainpb.TrustDomainClient
+	SVIDs          svidpb.SVIDClient
+	Debug          debugpb.DebugClient
+	LocalAuthority localauthoritypb.LocalAuthorityClient
+	Health         healthpb.HealthClient
+	// X509Source is the Workload API source of the spire-api SVID, nil with static certificates
+	X509Source *workloadapi.X509Source
+	// TrustDomain is the trust domain of the SPIRE server, entries must use it
+	TrustDomain string
+	// ServerAddress is the host:port of the SPIRE server, the first one of an HA deployment
+	ServerAddress string
+	// Replicas of an HA deployment, config changes are applied and confirmed on each
+	Replicas []ReplicaConfig
+	// Reload is how the SPIRE server is told to re-read its config
+	Reload ReloadConfig
+	// KubeconfigCrypto is nil when kubeconfigs are stored in plaintext
+	KubeconfigCrypto *KubeconfigCrypto
+	// AgentConfTemplate replaces the default agent.conf of join tokens when set
+	AgentConfTemplate *template.Template
+
+	// certs are the reloaded static certificates, nil in the other modes
+	certs *certReloader
+	// pinnedConn carries the per-server APIs of an HA backend, nil with a single server
+	pinnedConn *grpc.ClientConn
 }
 
 // create structs for SPIRE configurations for K8S and Bundle
@@ -39,3 +121,8 @@ type K8SBundleConfig struct {
 type BundleCluster struct {
 	KubeConfigFilePath string `json:"kube_config_file_path"`
 }
+
+// log returns the logger of the client with the request ID of ctx
+func (sc *SPIREClient) log(ctx context.Context) *logrus.Entry {
+	return logging.From(ctx, sc.Logger)
+}
@@ -0,0 +1,99 @@
+package spire_grpc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+const (
+	dns1123LabelMaxLength     = 63
+	dns1123SubdomainMaxLength = 253
+)
+
+var (
+	dns1123Label     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// FieldError describes an invalid field of a request, Field is the JSON name
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (ve *ValidationError) Error() string {
+	var msgs []string
+	for _, f := range ve.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+func (ve *ValidationError) add(field, msg string) {
+	ve.Fields = append(ve.Fields, FieldError{Field: field, Message: msg})
+}
+
+// ValidateDNS1123Label checks a Kubernetes namespace or cluster name
+func ValidateDNS1123Label(s string) error {
+	if len(s) > dns1123LabelMaxLength {
+		return fmt.Errorf("must be no more than %d characters", dns1123LabelMaxLength)
+	}
+	if !dns1123Label.MatchString(s) {
+		return fmt.Errorf("must be a DNS-1123 label: lower case alphanumeric characters or '-', starting and ending with an alphanumeric character")
+	}
+	return nil
+}
+
+// ValidateDNS1123Subdomain checks a Kubernetes service account name
+func ValidateDNS1123Subdomain(s string) error {
+	if len(s) > dns1123SubdomainMaxLength {
+		return fmt.Errorf("must be no more than %d characters", dns1123SubdomainMaxLength)
+	}
+	if !dns1123Subdomain.MatchString(s) {
+		return fmt.Errorf("must be a DNS-1123 subdomain: lower case alphanumeric characters, '-' or '.', starting and ending with an alphanumeric character")
+	}
+	return nil
+}
+
+// ValidateEntry checks the names used in file paths, SPIFFE IDs and selectors, and that the
+// trust domain is the one this client is configured for. Empty fields are left to the required checks.
+func (sc *SPIREClient) ValidateEntry(e *Entry) error {
+	ve := &ValidationError{}
+	if e.TrustDomain != "" && sc.TrustDomain != "" && e.TrustDomain != sc.TrustDomain {
+		ve.add("trustDomain", fmt.Sprintf("must be %q", sc.TrustDomain))
+	}
+	if e.Namespace != "" {
+		if err := ValidateDNS1123Label(e.Namespace); err != nil {
+			ve.add("namespace", err.Error())
+		}
+	}
+	if e.ServiceAccount != "" {
+		if err := ValidateDNS1123Subdomain(e.ServiceAccount); err != nil {
+			ve.add("serviceAccount", err.Error())
+		}
+	}
+	if e.Cluster != "" {
+		if err := ValidateDNS1123Label(e.Cluster); err != nil {
+			ve.add("cluster", err.Error())
+		}
+	}
+	for i, td := range e.FederatesWith {
+		field := fmt.Sprintf("federatesWith[%d]", i)
+		if _, err := spiffeid.TrustDomainFromString(td); err != nil {
+			ve.add(field, err.Error())
+		} else if td == sc.TrustDomain {
+			ve.add(field, "must be a foreign trust domain")
+		}
+	}
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
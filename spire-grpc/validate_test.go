@@ -0,0 +1,58 @@
+package spire_grpc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateEntry(t *testing.T) {
+	sc := &SPIREClient{TrustDomain: "example.org"}
+	valid := Entry{TrustDomain: "example.org", Cluster: "ambient-a", Namespace: "apps", ServiceAccount: "web.frontend"}
+	tests := []struct {
+		name   string
+		change func(e *Entry)
+		fields []string
+	}{
+		{name: "valid", change: func(e *Entry) {}},
+		{name: "cluster path traversal", change: func(e *Entry) { e.Cluster = "../../etc/passwd" }, fields: []string{"cluster"}},
+		{name: "cluster with a slash", change: func(e *Entry) { e.Cluster = "a/b" }, fields: []string{"cluster"}},
+		{name: "namespace upper case", change: func(e *Entry) { e.Namespace = "Apps" }, fields: []string{"namespace"}},
+		{name: "namespace too long", change: func(e *Entry) { e.Namespace = strings.Repeat("a", 64) }, fields: []string{"namespace"}},
+		{name: "namespace with a dot", change: func(e *Entry) { e.Namespace = "apps.prod" }, fields: []string{"namespace"}},
+		{name: "service account with a selector separator", change: func(e *Entry) { e.ServiceAccount = "web:admin" }, fields: []string{"serviceAccount"}},
+		{name: "service account ending with a dash", change: func(e *Entry) { e.ServiceAccount = "web-" }, fields: []string{"serviceAccount"}},
+		{name: "other trust domain", change: func(e *Entry) { e.TrustDomain = "partner.example.org" }, fields: []string{"trustDomain"}},
+		{name: "federates with itself", change: func(e *Entry) { e.FederatesWith = []string{"example.org"} }, fields: []string{"federatesWith[0]"}},
+		{name: "federates with an invalid trust domain", change: func(e *Entry) { e.FederatesWith = []string{"partner.example.org", "Partner!"} }, fields: []string{"federatesWith[1]"}},
+		{
+			name:   "every invalid field is reported",
+			change: func(e *Entry) { e.Cluster, e.Namespace, e.ServiceAccount = "..", "-", "/" },
+			fields: []string{"namespace", "serviceAccount", "cluster"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := valid
+			tt.change(&e)
+			err := sc.ValidateEntry(&e)
+			if len(tt.fields) == 0 {
+				if err != nil {
+					t.Errorf("ValidateEntry() = %v", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("ValidateEntry() = %v, want a ValidationError", err)
+			}
+			var got []string
+			for _, f := range ve.Fields {
+				got = append(got, f.Field)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.fields, ",") {
+				t.Errorf("invalid fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// ServiceName is the default service.name of the spans and the name of the tracer
+const ServiceName = "spire-api"
+
+// Config selects the span exporter. The OTLP exporter also reads the standard OTEL_EXPORTER_OTLP_*
+// environment variables, the settings here win.
+type Config struct {
+	// Exporter is none (default), otlp (gRPC) or stdout for local debugging
+	Exporter string `json:"exporter" yaml:"exporter"`
+	// Endpoint is the host:port of the OTLP collector, default localhost:4317
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	// Insecure disables TLS to the collector
+	Insecure bool              `json:"insecure,omitempty" yaml:"insecure,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// SampleRatio is the share of traces started by spire-api that are sampled, traces of
+	// callers follow their sampling decision
+	SampleRatio float64 `json:"sampleRatio" yaml:"sampleRatio"`
+	ServiceName string  `json:"serviceName,omitempty" yaml:"serviceName,omitempty"`
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	switch c.Exporter {
+	case "", ExporterNone, ExporterOTLP, ExporterStdout:
+	default:
+		errs = append(errs, fmt.Errorf("exporter: %q must be one of none, otlp, stdout", c.Exporter))
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("sampleRatio: %v must be between 0 and 1", c.SampleRatio))
+	}
+	return errors.Join(errs...)
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator. The returned
+// shutdown flushes the spans still buffered. With the exporter none spans are not recorded.
+func Setup(ctx context.Context, c Config, logger *logrus.Logger) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exp sdktrace.SpanExporter
+	var err error
+	switch c.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(c.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(c.Headers))
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter: %v", c.Exporter, err)
+	}
+	name := c.ServiceName
+	if name == "" {
+		name = ServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", name)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warnf("Tracing: %v", err)
+	}))
+	logger.Infof("Exporting traces with %s, sample ratio %v", c.Exporter, c.SampleRatio)
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the spire-api tracer of the global provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(ServiceName)
+}
+
+// Step runs fn in a child span of ctx, an error of fn fails the span
+func Step(ctx context.Context, name string, fn func(ctx context.Context) error, attrs ...attribute.KeyValue) error {
+	ctx, span := Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}